@@ -4,11 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/kelseyhightower/envconfig"
 	_ "github.com/lib/pq"
 	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/mail"
+	"github.com/mehmetkule/go-restapi/internal/oidc"
+	"github.com/mehmetkule/go-restapi/internal/password"
+	"github.com/mehmetkule/go-restapi/internal/signing"
 	"github.com/mehmetkule/go-restapi/internal/store"
+	"github.com/mehmetkule/go-restapi/internal/throttle"
 	"github.com/mehmetkule/go-restapi/logger"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v2"
@@ -32,9 +38,13 @@ type ServerConfig struct {
 		// time until idle session is closed
 		Idle time.Duration `yaml:"idle" default:90`
 	} `yaml:"timeout"`
+	// address the api is reachable at, used for links in emails
+	PublicURL string `yaml:"public_url" envconfig:"PUBLIC_URL"`
 }
 
 type EmailConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
 	Email    string `yaml:"email"`
 	Password string `yaml:"password"`
 	Target   string `yaml:"target"`
@@ -46,15 +56,42 @@ type Config struct {
 	ServerConfig ServerConfig   `yaml:"server"`
 	DBConfig     DbConfig `yaml:"database"`
 	EmailConfig  EmailConfig    `yaml:"smtp"`
+	AuthConfig   AuthConfig     `yaml:"auth"`
+	JWTConfig    JWTConfig      `yaml:"jwt"`
+	PasswordConfig PasswordConfig `yaml:"password_hashing"`
+	PasswordPolicy PasswordPolicyConfig `yaml:"password_policy"`
+	OIDCConfig     OIDCConfig           `yaml:"oidc"`
+	Retention      RetentionConfig      `yaml:"retention"`
 }
 
 type App struct {
-	conf         *Config
-	db           *sql.DB
-	Router       *mux.Router
-	ShutdownHook func()
-	userRepo     *store.UserRepo
-	filesRepo    *store.FilesRepo
+	conf             *Config
+	db               *sql.DB
+	Router           *mux.Router
+	ShutdownHook     func()
+	userRepo         *store.UserRepo
+	filesRepo        *store.FilesRepo
+	refreshTokenRepo *store.RefreshTokenRepo
+	revocationRepo   *store.RevocationRepo
+	twoFactorRepo    *store.TwoFactorRepo
+	keySet           *signing.KeySet
+	userTokenRepo    *store.UserTokenRepo
+	ipThrottle       *throttle.Tracker
+	magicThrottle    *throttle.Tracker
+	passwordPolicy   *password.Policy
+	apiKeyRepo       *store.APIKeyRepo
+	identityRepo     *store.IdentityRepo
+	sessionRepo      *store.SessionRepo
+	impersonateRepo  *store.ImpersonationRepo
+	privacyRepo      *store.PrivacyRepo
+	orgRepo          *store.OrgRepo
+	invitationRepo   *store.InvitationRepo
+	// erasureWake starts processing erasure requests before the next interval
+	erasureWake chan struct{}
+	// oidcProvider is nil when login through an external provider is not configured
+	oidcProvider *oidc.Provider
+	// mailSender can be replaced before Initialize, e.g. in tests
+	mailSender mail.Sender
 }
 
 // NewConfig creates a new config from yaml file
@@ -62,7 +99,13 @@ type App struct {
 func NewConfig(configPath string) (*Config, error) {
 	logger.Logger().Info("reading from config path", zap.String("configPath", configPath))
 
-	config := &Config{}
+	config := &Config{
+		AuthConfig:     defaultAuthConfig(),
+		EmailConfig:    EmailConfig{Host: "smtp.gmail.com", Port: "587"},
+		PasswordConfig: defaultPasswordConfig(),
+		PasswordPolicy: defaultPasswordPolicyConfig(),
+		Retention:      defaultRetentionConfig(),
+	}
 	file, err := os.Open(configPath)
 	if err != nil {
 		return nil, err
@@ -101,11 +144,55 @@ func (app *App) Initialize() error {
 		logger.Logger().Error("failed to get postgres connection", zap.Error(err))
 		return err
 	}
+	app.keySet, err = app.conf.JWTConfig.GetKeySet(app.conf.PasswordKey)
+	if err != nil {
+		logger.Logger().Error("failed to load jwt keys", zap.Error(err))
+		return err
+	}
+	hasher, err := app.conf.PasswordConfig.GetHasher()
+	if err != nil {
+		logger.Logger().Error("failed to create password hasher", zap.Error(err))
+		return err
+	}
+	app.passwordPolicy, err = app.conf.PasswordPolicy.GetPolicy()
+	if err != nil {
+		logger.Logger().Error("failed to load password policy", zap.Error(err))
+		return err
+	}
+	app.db = database
 	app.filesRepo = &store.FilesRepo{DB: database}
-	app.userRepo = &store.UserRepo{DB: database}
+	app.userRepo = &store.UserRepo{DB: database, Hasher: hasher}
+	app.refreshTokenRepo = &store.RefreshTokenRepo{DB: database}
+	app.twoFactorRepo = &store.TwoFactorRepo{DB: database}
+	app.userTokenRepo = &store.UserTokenRepo{DB: database}
+	app.apiKeyRepo = &store.APIKeyRepo{DB: database}
+	app.identityRepo = &store.IdentityRepo{DB: database}
+	app.sessionRepo = store.NewSessionRepo(database)
+	app.impersonateRepo = &store.ImpersonationRepo{DB: database}
+	app.privacyRepo = &store.PrivacyRepo{DB: database}
+	app.orgRepo = &store.OrgRepo{DB: database}
+	app.invitationRepo = &store.InvitationRepo{DB: database, Hasher: hasher}
+	app.erasureWake = make(chan struct{}, 1)
+	app.oidcProvider, err = app.conf.OIDCConfig.GetProvider(app.conf.ServerConfig.PublicURL, app.conf.PasswordKey)
+	if err != nil {
+		logger.Logger().Error("failed to configure oidc login", zap.Error(err))
+		return err
+	}
+	app.ipThrottle = throttle.NewTracker(app.conf.AuthConfig.IPThrottle.Policy())
+	app.magicThrottle = throttle.NewTracker(app.conf.AuthConfig.MagicLinkThrottle.Policy())
+	app.revocationRepo = store.NewRevocationRepo(database, app.conf.AuthConfig.RevocationCacheTTL)
+	if app.mailSender == nil {
+		emailConfig := app.conf.EmailConfig
+		app.mailSender = mail.NewSMTPSender(emailConfig.Host, emailConfig.Port, emailConfig.Email, emailConfig.Password, emailConfig.Email)
+	}
 	app.AddRoutes()
 
+	stopJobs := make(chan struct{})
+	go app.purgeDeletedUsers(stopJobs)
+	go app.processErasures(stopJobs)
+
 	app.ShutdownHook = func() {
+		close(stopJobs)
 		logger.Logger().Info("Closing database connections....")
 		if app != nil && app.db != nil {
 			app.db.Close()
@@ -159,28 +246,39 @@ func (app *App) AddRoute(method string, route string, apiHandler func(w http.Res
 	app.Router.HandleFunc(route, apiHandler).Methods(method)
 }
 
-// AddRouteWithMiddleware wraps the route in middleware
-func (app *App) AddRouteWithMiddleware(method string, route string, apiHandler func(w http.ResponseWriter, r *http.Request), middleware func(next http.Handler) http.Handler) {
-	handler := middleware(http.HandlerFunc(apiHandler))
+// AddRouteWithMiddleware wraps the route in middlewares, the first one runs first
+func (app *App) AddRouteWithMiddleware(method string, route string, apiHandler func(w http.ResponseWriter, r *http.Request), middlewares ...func(next http.Handler) http.Handler) {
+	var handler http.Handler = http.HandlerFunc(apiHandler)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
 	app.Router.Handle(route, handler).Methods(method)
 }
 
 // RenderErrorResponse render error response
 func (app *App) RenderErrorResponse(writer http.ResponseWriter, httpStatus int, err error, message string) {
 	logger.Logger().Error("Error render response", zap.Error(err), zap.String("", message))
-	writer.WriteHeader(httpStatus)
-	app.RenderJSON(writer, httpStatus, dto.ErrorResponse{Status: httpStatus, Error: err, Message: message})
+	response := dto.ErrorResponse{Status: httpStatus, Error: err, Message: message}
+	var validationErr *dto.ValidationError
+	if errors.As(err, &validationErr) {
+		response.Fields = validationErr.Fields
+	}
+	app.RenderJSON(writer, httpStatus, response)
 }
+
+// RenderJSON writes data as json with status, headers have to be set before
 func (app *App) RenderJSON(writer http.ResponseWriter, status int, data interface{}) {
-	writer.Header().Set("Content-Type", "application/json")
-	writer.Header().Set("Access-Control-Allow-Origin", "*")
-	writer.Header().Set("Origin", "*")
+	var jsonData []byte
 	if data != nil {
-		jsonData, err := json.Marshal(data)
-		if err != nil {
+		var err error
+		if jsonData, err = json.Marshal(data); err != nil {
 			http.Error(writer, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		writer.Write(jsonData)
 	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Access-Control-Allow-Origin", "*")
+	writer.Header().Set("Origin", "*")
+	writer.WriteHeader(status)
+	writer.Write(jsonData)
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// apiKeyPrefix starts every api key so leaked keys are easy to recognize
+const apiKeyPrefix = "rap_"
+
+// apiKeyHeader carries api keys of machine clients
+const apiKeyHeader = "X-API-Key"
+
+// CreateAPIKey creates an api key of the authenticated user, the key is only returned once
+func (app *App) CreateAPIKey(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	var req dto.APIKeyRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if status, errValidate := req.ValidateAPIKey(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+	// requests made with api keys would escape the impersonation log
+	if principal.Actor != nil {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Not allowed while impersonating")
+		return
+	}
+	// keys can not grant more than their owner may do
+	for _, scope := range req.Scopes {
+		if scope == dto.ScopeUsersAdmin && !principal.HasAnyRole(dto.RoleAdmin) {
+			app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Insufficient permissions")
+			return
+		}
+	}
+
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to create api key")
+		return
+	}
+	var expires *time.Time
+	if req.ExpiresIn > 0 {
+		at := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		expires = &at
+	}
+	response, errResponse := app.apiKeyRepo.CreateAPIKey(request.Context(), principal.UserID, req.Name, prefix, hashToken(key), req.Scopes, expires)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	response.Key = key
+	logger.Logger().Info("Api key created", zap.String("email", principal.Email), zap.String("prefix", prefix))
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, response)
+}
+
+// GetAPIKeys lists the api keys of the authenticated user
+func (app *App) GetAPIKeys(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	keys, errResponse := app.apiKeyRepo.GetAPIKeys(request.Context(), principal.UserID)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, keys)
+}
+
+// RevokeAPIKey revokes an api key of the authenticated user
+func (app *App) RevokeAPIKey(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	id := mux.Vars(request)["id"]
+	if errResponse := app.apiKeyRepo.RevokeAPIKey(request.Context(), principal.UserID, id); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, "Api key revoked")
+}
+
+// APIKeyHandler authenticates machine clients by an api key granted one of scopes
+// and falls back to JWTHandler for requests without one. Routes not using it do
+// not accept api keys at all.
+func (app *App) APIKeyHandler(scopes ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		jwtHandler := app.JWTHandler(next)
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			key := request.Header.Get(apiKeyHeader)
+			if key == "" {
+				jwtHandler.ServeHTTP(response, request)
+				return
+			}
+			if !strings.HasPrefix(key, apiKeyPrefix) {
+				app.RenderErrorResponse(response, http.StatusForbidden, nil, "Invalid api key")
+				return
+			}
+			owner, errResponse := app.apiKeyRepo.UseAPIKey(request.Context(), hashToken(key))
+			if errResponse != nil {
+				app.RenderErrorResponse(response, errResponse.Status, errResponse.Error, errResponse.Message)
+				return
+			}
+			principal := &auth.Principal{
+				UserID:     owner.UserID,
+				Email:      owner.Email,
+				Roles:      []string{owner.Role},
+				TenantID:   owner.OrgID,
+				TenantRole: owner.OrgRole,
+				APIKeyID:   owner.KeyID,
+				Scopes:     owner.Scopes,
+			}
+			if !principal.HasAnyScope(scopes...) {
+				app.RenderErrorResponse(response, http.StatusForbidden, nil, "Insufficient scope")
+				return
+			}
+			next.ServeHTTP(response, request.WithContext(auth.WithPrincipal(request.Context(), principal)))
+		})
+	}
+}
+
+// generateAPIKey creates a random api key and its visible prefix
+func generateAPIKey() (string, string, error) {
+	data := make([]byte, 5)
+	if _, err := rand.Read(data); err != nil {
+		return "", "", err
+	}
+	prefix := apiKeyPrefix + strings.ToLower(base32.StdEncoding.EncodeToString(data))
+	secret, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	return prefix + "_" + secret, prefix, nil
+}
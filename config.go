@@ -3,8 +3,13 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"github.com/mehmetkule/go-restapi/internal/oidc"
+	"github.com/mehmetkule/go-restapi/internal/password"
+	"github.com/mehmetkule/go-restapi/internal/signing"
+	"github.com/mehmetkule/go-restapi/internal/throttle"
 	"github.com/mehmetkule/go-restapi/logger"
 	"go.uber.org/zap"
+	"time"
 )
 
 // DbConfig is configu struct for database
@@ -16,6 +21,272 @@ type DbConfig struct {
 	DbName   string `yaml:"name" envconfig:"DB_NAME"`
 }
 
+// AuthConfig is config struct for authentication and issued tokens
+type AuthConfig struct {
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" envconfig:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" envconfig:"REFRESH_TOKEN_TTL"`
+	// how long revoked tokens are served from memory before reloading
+	RevocationCacheTTL time.Duration `yaml:"revocation_cache_ttl" envconfig:"REVOCATION_CACHE_TTL"`
+	// lifetime of the token between password and second factor login steps
+	TwoFactorTokenTTL time.Duration `yaml:"two_factor_token_ttl" envconfig:"TWO_FACTOR_TOKEN_TTL"`
+	PasswordResetTTL  time.Duration `yaml:"password_reset_ttl" envconfig:"PASSWORD_RESET_TTL"`
+	// page of the frontend the reset token is appended to, the email only contains the token when empty
+	PasswordResetURL     string        `yaml:"password_reset_url" envconfig:"PASSWORD_RESET_URL"`
+	EmailVerificationTTL time.Duration `yaml:"email_verification_ttl" envconfig:"EMAIL_VERIFICATION_TTL"`
+	// blocks login until the email address is verified
+	RequireVerifiedEmail bool `yaml:"require_verified_email" envconfig:"REQUIRE_VERIFIED_EMAIL"`
+	// failed logins per account and per client ip
+	AccountThrottle ThrottleConfig `yaml:"account_throttle"`
+	IPThrottle      ThrottleConfig `yaml:"ip_throttle"`
+	MagicLinkTTL    time.Duration  `yaml:"magic_link_ttl" envconfig:"MAGIC_LINK_TTL"`
+	// page of the frontend the login token is appended to, the link points to the api when empty
+	MagicLinkURL string `yaml:"magic_link_url" envconfig:"MAGIC_LINK_URL"`
+	// login link, password reset and verification emails per email address
+	MagicLinkThrottle ThrottleConfig `yaml:"magic_link_throttle"`
+	// lifetime of tokens admins act as another user with
+	ImpersonationTTL time.Duration `yaml:"impersonation_ttl" envconfig:"IMPERSONATION_TTL"`
+	InvitationTTL    time.Duration `yaml:"invitation_ttl" envconfig:"INVITATION_TTL"`
+	// page of the frontend the invitation token is appended to, the email only contains the token when empty
+	InvitationURL string `yaml:"invitation_url" envconfig:"INVITATION_URL"`
+}
+
+// ThrottleConfig is config struct for backoff and lockout after failed logins
+type ThrottleConfig struct {
+	FreeAttempts     int           `yaml:"free_attempts"`
+	BaseDelay        time.Duration `yaml:"base_delay"`
+	MaxDelay         time.Duration `yaml:"max_delay"`
+	LockoutThreshold int           `yaml:"lockout_threshold"`
+	LockoutDuration  time.Duration `yaml:"lockout_duration"`
+	ResetAfter       time.Duration `yaml:"reset_after"`
+}
+
+// Policy converts the config to a throttle policy
+func (c ThrottleConfig) Policy() throttle.Policy {
+	return throttle.Policy{
+		FreeAttempts:     c.FreeAttempts,
+		BaseDelay:        c.BaseDelay,
+		MaxDelay:         c.MaxDelay,
+		LockoutThreshold: c.LockoutThreshold,
+		LockoutDuration:  c.LockoutDuration,
+		ResetAfter:       c.ResetAfter,
+	}
+}
+
+// defaultAuthConfig is used for values missing from config file
+func defaultAuthConfig() AuthConfig {
+	return AuthConfig{
+		AccessTokenTTL:       15 * time.Minute,
+		RefreshTokenTTL:      30 * 24 * time.Hour,
+		RevocationCacheTTL:   30 * time.Second,
+		TwoFactorTokenTTL:    5 * time.Minute,
+		PasswordResetTTL:     time.Hour,
+		EmailVerificationTTL: 24 * time.Hour,
+		AccountThrottle: ThrottleConfig{
+			FreeAttempts:     3,
+			BaseDelay:        time.Second,
+			MaxDelay:         time.Minute,
+			LockoutThreshold: 10,
+			LockoutDuration:  15 * time.Minute,
+			ResetAfter:       time.Hour,
+		},
+		IPThrottle: ThrottleConfig{
+			FreeAttempts:     10,
+			BaseDelay:        time.Second,
+			MaxDelay:         time.Minute,
+			LockoutThreshold: 100,
+			LockoutDuration:  time.Hour,
+			ResetAfter:       time.Hour,
+		},
+		MagicLinkTTL: 15 * time.Minute,
+		MagicLinkThrottle: ThrottleConfig{
+			FreeAttempts: 3,
+			BaseDelay:    time.Minute,
+			MaxDelay:     15 * time.Minute,
+			ResetAfter:   time.Hour,
+		},
+		ImpersonationTTL: 30 * time.Minute,
+		InvitationTTL:    7 * 24 * time.Hour,
+	}
+}
+
+// PasswordConfig is config struct for password hashing
+type PasswordConfig struct {
+	// algorithm new passwords are hashed with, bcrypt or argon2id
+	Algorithm  string `yaml:"algorithm" envconfig:"PASSWORD_ALGORITHM"`
+	BcryptCost int    `yaml:"bcrypt_cost" envconfig:"PASSWORD_BCRYPT_COST"`
+	// memory in KiB
+	Argon2Memory      uint32 `yaml:"argon2_memory" envconfig:"PASSWORD_ARGON2_MEMORY"`
+	Argon2Iterations  uint32 `yaml:"argon2_iterations" envconfig:"PASSWORD_ARGON2_ITERATIONS"`
+	Argon2Parallelism uint8  `yaml:"argon2_parallelism" envconfig:"PASSWORD_ARGON2_PARALLELISM"`
+}
+
+// defaultPasswordConfig is used for values missing from config file
+func defaultPasswordConfig() PasswordConfig {
+	return PasswordConfig{
+		Algorithm:         "argon2id",
+		BcryptCost:        12,
+		Argon2Memory:      19 * 1024,
+		Argon2Iterations:  2,
+		Argon2Parallelism: 1,
+	}
+}
+
+// GetHasher creates the password hasher, hashes of the other algorithm still verify
+func (c *PasswordConfig) GetHasher() (password.Hashers, error) {
+	return password.New(c.Algorithm,
+		password.Bcrypt{Cost: c.BcryptCost},
+		password.Argon2id{
+			Memory:      c.Argon2Memory,
+			Iterations:  c.Argon2Iterations,
+			Parallelism: c.Argon2Parallelism,
+			SaltLength:  16,
+			KeyLength:   32,
+		})
+}
+
+// PasswordPolicyConfig is config struct for the passwords users may choose
+type PasswordPolicyConfig struct {
+	MinLength     int  `yaml:"min_length" envconfig:"PASSWORD_MIN_LENGTH"`
+	RequireLower  bool `yaml:"require_lower"`
+	RequireUpper  bool `yaml:"require_upper"`
+	RequireDigit  bool `yaml:"require_digit"`
+	RequireSymbol bool `yaml:"require_symbol"`
+	DisallowEmail bool `yaml:"disallow_email"`
+	CheckBreached bool `yaml:"check_breached" envconfig:"PASSWORD_CHECK_BREACHED"`
+	// file of sha1 hashes replacing the bundled breached password list
+	BreachedList string `yaml:"breached_list" envconfig:"PASSWORD_BREACHED_LIST"`
+}
+
+// defaultPasswordPolicyConfig is used for values missing from config file
+func defaultPasswordPolicyConfig() PasswordPolicyConfig {
+	return PasswordPolicyConfig{
+		MinLength:     10,
+		RequireLower:  true,
+		RequireUpper:  true,
+		RequireDigit:  true,
+		DisallowEmail: true,
+		CheckBreached: true,
+	}
+}
+
+// GetPolicy creates the password policy, loading the breached password list if enabled
+func (c *PasswordPolicyConfig) GetPolicy() (*password.Policy, error) {
+	policy := &password.Policy{
+		MinLength:     c.MinLength,
+		RequireLower:  c.RequireLower,
+		RequireUpper:  c.RequireUpper,
+		RequireDigit:  c.RequireDigit,
+		RequireSymbol: c.RequireSymbol,
+		DisallowEmail: c.DisallowEmail,
+	}
+	if !c.CheckBreached {
+		return policy, nil
+	}
+
+	var err error
+	if c.BreachedList != "" {
+		policy.Breached, err = password.LoadBreachedList(c.BreachedList)
+	} else {
+		policy.Breached, err = password.BundledBreachedList()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// RetentionConfig is config struct for how long deleted data is kept
+type RetentionConfig struct {
+	// deleted users can be restored until they are purged, zero keeps them forever
+	DeletedUsers  time.Duration `yaml:"deleted_users" envconfig:"RETENTION_DELETED_USERS"`
+	PurgeInterval time.Duration `yaml:"purge_interval" envconfig:"RETENTION_PURGE_INTERVAL"`
+}
+
+// defaultRetentionConfig is used for values missing from config file
+func defaultRetentionConfig() RetentionConfig {
+	return RetentionConfig{
+		DeletedUsers:  30 * 24 * time.Hour,
+		PurgeInterval: time.Hour,
+	}
+}
+
+// OIDCConfig is config struct for signing in with an external OpenID Connect provider
+type OIDCConfig struct {
+	// login through the provider is disabled when empty
+	Issuer       string `yaml:"issuer" envconfig:"OIDC_ISSUER"`
+	ClientID     string `yaml:"client_id" envconfig:"OIDC_CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" envconfig:"OIDC_CLIENT_SECRET"`
+	// callback registered at the provider, public_url + /auth/oidc/callback when empty
+	RedirectURL string   `yaml:"redirect_url" envconfig:"OIDC_REDIRECT_URL"`
+	Scopes      []string `yaml:"scopes"`
+	// creates users for verified email addresses without an account instead of rejecting them
+	AutoRegister bool `yaml:"auto_register" envconfig:"OIDC_AUTO_REGISTER"`
+}
+
+// GetProvider creates the provider, nil when none is configured. The login state
+// is signed with a key derived from passwordKey, which must then be set to a
+// secret other than the committed default.
+func (c *OIDCConfig) GetProvider(publicURL string, passwordKey string) (*oidc.Provider, error) {
+	if c.Issuer == "" {
+		return nil, nil
+	}
+	if passwordKey == "" || passwordKey == committedPasswordKey {
+		return nil, fmt.Errorf("oidc login configured and password_key is not set to a secret, configure CERCI_PASSWORD_KEY")
+	}
+	redirectURL := c.RedirectURL
+	if redirectURL == "" {
+		redirectURL = publicURL + "/auth/oidc/callback"
+	}
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return oidc.NewProvider(c.Issuer, c.ClientID, c.ClientSecret, redirectURL, scopes), nil
+}
+
+// JWTConfig is config struct for token signing keys
+type JWTConfig struct {
+	// kid of the key new tokens are signed with
+	ActiveKey string `yaml:"active_key" envconfig:"JWT_ACTIVE_KEY"`
+	// keys other than the active one only verify tokens while they are rotated out
+	Keys []JWTKeyConfig `yaml:"keys"`
+}
+
+// JWTKeyConfig is config struct for a single signing key
+type JWTKeyConfig struct {
+	ID         string `yaml:"kid"`
+	Algorithm  string `yaml:"algorithm"`
+	PrivateKey string `yaml:"private_key"`
+	PublicKey  string `yaml:"public_key"`
+}
+
+// committedPasswordKey is the password key of the committed config.yaml, it is
+// public and must not sign tokens or oidc login states
+const committedPasswordKey = "CODONEX_PSOLUTIONS_CERCI"
+
+// GetKeySet loads the configured keys, without keys tokens are signed with HS256 and
+// passwordKey, which must then be set to a secret other than the committed default
+func (c *JWTConfig) GetKeySet(passwordKey string) (*signing.KeySet, error) {
+	if len(c.Keys) == 0 {
+		if passwordKey == "" || passwordKey == committedPasswordKey {
+			return nil, fmt.Errorf("no jwt keys configured and password_key is not set to a secret, configure jwt.keys or CERCI_PASSWORD_KEY")
+		}
+		logger.Logger().Warn("No jwt keys configured, signing tokens with password key")
+		return signing.NewKeySet("", signing.NewHMACKey("", []byte(passwordKey)))
+	}
+
+	var keys []*signing.Key
+	for _, keyConfig := range c.Keys {
+		key, err := signing.LoadKey(keyConfig.ID, keyConfig.Algorithm, keyConfig.PrivateKey, keyConfig.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	logger.Logger().Info("Loaded jwt keys", zap.String("activeKey", c.ActiveKey), zap.Int("keys", len(keys)))
+	return signing.NewKeySet(c.ActiveKey, keys...)
+}
+
 // GetDatabase creates database connection using postgres driver
 func (c *DbConfig) GetDatabase() (*sql.DB, error) {
 	dbInfo := fmt.Sprintf("host=%v port=%v user=%s password=%s dbname=%s sslmode=disable",c.Host,
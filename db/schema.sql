@@ -4,17 +4,220 @@ CREATE TABLE document(
     name character varying(150),
     data BYTEA NOT NULL,
     created date,
+    created_by uuid,
+    -- organization owning the document, null for documents uploaded outside of any
+    org_id uuid,
     CONSTRAINT document_pkey PRIMARY KEY (id)
 ) WITH(OIDS = FALSE);
 
+CREATE INDEX document_org_id_idx ON document(org_id);
+
 CREATE TABLE users(
     id uuid DEFAULT uuid_generate_v4 (),
     first_name text NOT NULL,
     last_name text NOT NULL,
     email text NOT NULL,
+    email_verified_at timestamp,
     password text NOT NULL,
     is_2fa bool,
+    totp_secret text,
+    totp_confirmed bool NOT NULL DEFAULT false,
+    totp_last_step bigint,
+    role text NOT NULL DEFAULT 'user',
+    failed_logins int NOT NULL DEFAULT 0,
+    last_failed_login timestamp,
     token text,
     created date,
-    CONSTRAINT users_pkey PRIMARY KEY (id)
-) WITH (OIDS = FALSE);
\ No newline at end of file
+    created_by uuid,
+    updated timestamp,
+    deleted_at timestamp,
+    CONSTRAINT users_pkey PRIMARY KEY (id),
+    CONSTRAINT users_role_check CHECK (role IN ('admin', 'user'))
+) WITH (OIDS = FALSE);
+
+-- addresses differing only in case belong to the same user, deleted users do not hold on to theirs
+CREATE UNIQUE INDEX users_email_key ON users(lower(email)) WHERE deleted_at IS NULL;
+CREATE INDEX users_deleted_at_idx ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+
+CREATE TABLE refresh_tokens(
+    id uuid DEFAULT uuid_generate_v4 (),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id uuid NOT NULL,
+    token_hash text NOT NULL,
+    expires timestamp NOT NULL,
+    revoked timestamp,
+    replaced_by uuid,
+    created timestamp,
+    CONSTRAINT refresh_tokens_pkey PRIMARY KEY (id),
+    CONSTRAINT refresh_tokens_token_hash_key UNIQUE (token_hash)
+) WITH (OIDS = FALSE);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens(family_id);
+
+CREATE TABLE revoked_tokens(
+    jti uuid NOT NULL,
+    user_id uuid NOT NULL,
+    expires timestamp NOT NULL,
+    created timestamp,
+    CONSTRAINT revoked_tokens_pkey PRIMARY KEY (jti)
+) WITH (OIDS = FALSE);
+
+CREATE TABLE user_token_revocations(
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    revoked_before timestamp NOT NULL,
+    CONSTRAINT user_token_revocations_pkey PRIMARY KEY (user_id)
+) WITH (OIDS = FALSE);
+
+CREATE TABLE recovery_codes(
+    id uuid DEFAULT uuid_generate_v4 (),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash text NOT NULL,
+    used timestamp,
+    created timestamp,
+    CONSTRAINT recovery_codes_pkey PRIMARY KEY (id)
+) WITH (OIDS = FALSE);
+
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes(user_id);
+
+CREATE TABLE audit_log(
+    id uuid DEFAULT uuid_generate_v4 (),
+    actor_id uuid,
+    action text NOT NULL,
+    entity text NOT NULL,
+    entity_id text NOT NULL,
+    created timestamp,
+    CONSTRAINT audit_log_pkey PRIMARY KEY (id)
+) WITH (OIDS = FALSE);
+
+CREATE TABLE user_tokens(
+    id uuid DEFAULT uuid_generate_v4 (),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose text NOT NULL,
+    token_hash text NOT NULL,
+    expires timestamp NOT NULL,
+    used timestamp,
+    created timestamp,
+    CONSTRAINT user_tokens_pkey PRIMARY KEY (id),
+    CONSTRAINT user_tokens_token_hash_key UNIQUE (token_hash)
+) WITH (OIDS = FALSE);
+
+CREATE TABLE api_keys(
+    id uuid DEFAULT uuid_generate_v4 (),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    key_hash text NOT NULL,
+    scopes text NOT NULL,
+    expires timestamp,
+    last_used timestamp,
+    revoked timestamp,
+    created timestamp,
+    -- organization the key acts in, keys stop working once the user leaves it
+    org_id uuid,
+    CONSTRAINT api_keys_pkey PRIMARY KEY (id),
+    CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash)
+) WITH (OIDS = FALSE);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys(user_id);
+
+CREATE TABLE user_identities(
+    id uuid DEFAULT uuid_generate_v4 (),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    email text,
+    created timestamp,
+    CONSTRAINT user_identities_pkey PRIMARY KEY (id),
+    CONSTRAINT user_identities_issuer_subject_key UNIQUE (issuer, subject)
+) WITH (OIDS = FALSE);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities(user_id);
+
+CREATE TABLE sessions(
+    id uuid NOT NULL,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    ip text,
+    user_agent text,
+    created timestamp NOT NULL,
+    last_seen timestamp NOT NULL,
+    expires timestamp NOT NULL,
+    revoked timestamp,
+    -- organization the access tokens of the session are issued for
+    org_id uuid,
+    CONSTRAINT sessions_pkey PRIMARY KEY (id)
+) WITH (OIDS = FALSE);
+
+CREATE INDEX sessions_user_id_idx ON sessions(user_id);
+
+CREATE TABLE impersonation_log(
+    id uuid DEFAULT uuid_generate_v4 (),
+    actor_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    token_id uuid NOT NULL,
+    method text NOT NULL,
+    path text NOT NULL,
+    status int NOT NULL,
+    ip text,
+    created timestamp,
+    CONSTRAINT impersonation_log_pkey PRIMARY KEY (id)
+) WITH (OIDS = FALSE);
+
+CREATE INDEX impersonation_log_actor_id_idx ON impersonation_log(actor_id);
+CREATE INDEX impersonation_log_user_id_idx ON impersonation_log(user_id);
+
+CREATE TABLE privacy_requests(
+    id uuid DEFAULT uuid_generate_v4 (),
+    user_id uuid NOT NULL,
+    kind text NOT NULL,
+    status text NOT NULL,
+    requested_by uuid,
+    error text,
+    created timestamp NOT NULL,
+    completed timestamp,
+    CONSTRAINT privacy_requests_pkey PRIMARY KEY (id),
+    CONSTRAINT privacy_requests_kind_check CHECK (kind IN ('export', 'erasure'))
+) WITH (OIDS = FALSE);
+
+CREATE INDEX privacy_requests_user_id_idx ON privacy_requests(user_id);
+CREATE INDEX privacy_requests_pending_idx ON privacy_requests(created) WHERE status = 'pending';
+
+CREATE TABLE organizations(
+    id uuid DEFAULT uuid_generate_v4 (),
+    name text NOT NULL,
+    created timestamp NOT NULL,
+    created_by uuid,
+    CONSTRAINT organizations_pkey PRIMARY KEY (id)
+) WITH (OIDS = FALSE);
+
+CREATE TABLE organization_members(
+    org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role text NOT NULL DEFAULT 'member',
+    created timestamp NOT NULL,
+    CONSTRAINT organization_members_pkey PRIMARY KEY (org_id, user_id),
+    CONSTRAINT organization_members_role_check CHECK (role IN ('owner', 'admin', 'member'))
+) WITH (OIDS = FALSE);
+
+CREATE INDEX organization_members_user_id_idx ON organization_members(user_id);
+
+CREATE TABLE invitations(
+    id uuid DEFAULT uuid_generate_v4 (),
+    email text NOT NULL,
+    role text NOT NULL DEFAULT 'user',
+    -- organization the invitee joins with org_role, null for invitations outside of any
+    org_id uuid REFERENCES organizations(id) ON DELETE CASCADE,
+    org_role text,
+    token_hash text NOT NULL,
+    invited_by uuid,
+    expires timestamp NOT NULL,
+    accepted timestamp,
+    user_id uuid,
+    revoked timestamp,
+    created timestamp NOT NULL,
+    CONSTRAINT invitations_pkey PRIMARY KEY (id),
+    CONSTRAINT invitations_token_hash_key UNIQUE (token_hash),
+    CONSTRAINT invitations_role_check CHECK (role IN ('admin', 'user')),
+    CONSTRAINT invitations_org_role_check CHECK (org_role IN ('owner', 'admin', 'member'))
+) WITH (OIDS = FALSE);
+
+CREATE INDEX invitations_email_idx ON invitations(lower(email));
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+)
+
+// fakeResult is the answer of a fakeDB to a statement
+type fakeResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+}
+
+// fakeDB is a database/sql driver answering every statement with handle, so
+// handlers can be tested without a postgres server. Statements are answered one
+// at a time, handle needs no locking of its own.
+type fakeDB struct {
+	mu     sync.Mutex
+	handle func(query string, args []driver.Value) (*fakeResult, error)
+}
+
+// newFakeDB opens a database answering statements with handle
+func newFakeDB(handle func(query string, args []driver.Value) (*fakeResult, error)) *sql.DB {
+	return sql.OpenDB(&fakeDB{handle: handle})
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return db }
+func (db *fakeDB) Open(string) (driver.Conn, error)             { return &fakeConn{db: db}, nil }
+
+// answer runs query with named args through handle
+func (db *fakeDB) answer(query string, named []driver.NamedValue) (*fakeResult, error) {
+	args := make([]driver.Value, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	result, err := db.handle(query, args)
+	if result == nil && err == nil {
+		result = &fakeResult{}
+	}
+	return result, err
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeConn) Commit() error             { return nil }
+func (c *fakeConn) Rollback() error           { return nil }
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.db.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(result.affected), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.db.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{result: result}, nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+// namedValues numbers args the way positional parameters are
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type fakeRows struct {
+	result *fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string {
+	if r.result.columns != nil {
+		return r.result.columns
+	}
+	if len(r.result.rows) > 0 {
+		return make([]string, len(r.result.rows[0]))
+	}
+	return nil
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
@@ -45,7 +45,7 @@ func (app *App) AddFile(writer http.ResponseWriter, req *http.Request) {
 	}
 
 	// database process
-	response, errResponse := app.filesRepo.InsertFiles(files, parentID)
+	response, errResponse := app.filesRepo.InsertFiles(req.Context(), files, parentID)
 	if errResponse != nil {
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
@@ -64,7 +64,7 @@ func (app *App) FindFile(writer http.ResponseWriter, req *http.Request) {
 	id := params["id"]
 
 	// database process
-	response, errResponse := app.filesRepo.FindFile(id)
+	response, errResponse := app.filesRepo.FindFile(req.Context(), id)
 	if errResponse != nil {
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
@@ -84,7 +84,7 @@ func (app *App) FindFiles(writer http.ResponseWriter, req *http.Request) {
 	parentID := params["parent_id"]
 
 	// database process
-	response, errResponse := app.filesRepo.FindFiles(parentID)
+	response, errResponse := app.filesRepo.FindFiles(req.Context(), parentID)
 	if errResponse != nil {
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
@@ -102,7 +102,7 @@ func (app *App) DeleteFile(writer http.ResponseWriter, req *http.Request) {
 	id := params["id"]
 
 	// database process
-	errResponse := app.filesRepo.DeleteFileWithID(id)
+	errResponse := app.filesRepo.DeleteFileWithID(req.Context(), id)
 	if errResponse != nil {
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
@@ -119,7 +119,7 @@ func (app *App) DeleteFiles(writer http.ResponseWriter, req *http.Request) {
 	parentID := params["parent_id"]
 
 	// database process
-	errResponse := app.filesRepo.DeleteFilesWithParent(parentID)
+	errResponse := app.filesRepo.DeleteFilesWithParent(req.Context(), parentID)
 	if errResponse != nil {
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/store"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// Impersonate issues an admin a short lived access token acting as another user,
+// the token names the admin in its act claim and cannot be refreshed
+func (app *App) Impersonate(writer http.ResponseWriter, req *http.Request) {
+	principal, ok := auth.PrincipalFromContext(req.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	if principal.Actor != nil {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Not allowed while impersonating")
+		return
+	}
+	id, err := uuid.FromString(mux.Vars(req)["id"])
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid id")
+		return
+	}
+	if id.String() == principal.UserID {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Can not impersonate yourself")
+		return
+	}
+	user, errResponse := app.userRepo.GetUserByID(id)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	// admins impersonating admins would hide who did what
+	if user.Role == dto.RoleAdmin {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Admins can not be impersonated")
+		return
+	}
+
+	// the token acts in the organization the user logs in to
+	membership, errResponse := app.orgRepo.DefaultMembership(req.Context(), user.ID)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	token, err := app.newImpersonationToken(user, principal, membership)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to create token")
+		return
+	}
+	if err = app.impersonateRepo.RecordImpersonation(req.Context(), user.ID); err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to create token")
+		return
+	}
+	logger.Logger().Warn("Impersonation started", zap.String("admin", principal.Email), zap.String("email", user.Email))
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, dto.JWTToken{
+		Token:     token,
+		ExpiresIn: int64(app.conf.AuthConfig.ImpersonationTTL.Seconds()),
+	})
+}
+
+// newImpersonationToken signs an access token of user acting in the organization of
+// membership with actor in the act claim, it belongs to no session so session
+// management of the user is not affected
+func (app *App) newImpersonationToken(user *dto.User, actor *auth.Principal, membership *dto.Membership) (string, error) {
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := Claims{
+		Email:      user.Email,
+		IsUsing2FA: user.IsUsing2FA,
+		Roles:      []string{user.Role},
+		Actor:      &ActorClaim{Subject: actor.UserID, Email: actor.Email},
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti.String(),
+			Subject:   user.ID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(app.conf.AuthConfig.ImpersonationTTL).Unix(),
+		},
+	}
+	if membership != nil {
+		claims.TenantID, claims.TenantRole = membership.OrgID, membership.Role
+	}
+	return app.keySet.Sign(claims)
+}
+
+// serveImpersonated serves a request made with an impersonation token and
+// records it in the impersonation log
+func (app *App) serveImpersonated(writer http.ResponseWriter, req *http.Request, next http.Handler) {
+	recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+	next.ServeHTTP(recorder, req)
+
+	principal, _ := auth.PrincipalFromContext(req.Context())
+	err := app.impersonateRepo.RecordImpersonatedRequest(req.Context(), store.ImpersonatedRequest{
+		ActorID: principal.Actor.UserID,
+		UserID:  principal.UserID,
+		TokenID: principal.TokenID,
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Status:  recorder.status,
+		IP:      clientIP(req),
+	})
+	if err != nil {
+		logger.Logger().Error("Failed to record impersonated request", zap.Error(err),
+			zap.String("admin", principal.Actor.Email), zap.String("path", req.URL.Path))
+	}
+}
+
+// statusRecorder remembers the status code written to the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records status before writing it
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
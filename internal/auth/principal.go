@@ -0,0 +1,103 @@
+// Package auth carries the authenticated identity of a request through context.Context.
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey string
+
+// principalContextKey holds the principal of the authenticated request
+const principalContextKey contextKey = "principal"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID    string
+	Email     string
+	Roles     []string
+	TokenID   string
+	ExpiresAt time.Time
+	// SessionID is the login session the token was issued for
+	SessionID string
+	// Actor is the admin acting as the user, nil unless impersonated
+	Actor *Actor
+	// TenantID is the organization the caller acts in, empty outside of any,
+	// TenantRole is the role of the caller in it
+	TenantID   string
+	TenantRole string
+	// APIKeyID is set when the caller authenticated with an api key limited to Scopes
+	APIKeyID string
+	Scopes   []string
+}
+
+// Actor is the real caller of an impersonated request
+type Actor struct {
+	UserID string
+	Email  string
+}
+
+// HasAnyRole reports whether principal has one of roles
+func (p *Principal) HasAnyRole(roles ...string) bool {
+	for _, role := range roles {
+		for _, granted := range p.Roles {
+			if role == granted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasAnyScope reports whether principal was granted one of scopes, callers
+// authenticated with a token are not limited by scopes
+func (p *Principal) HasAnyScope(scopes ...string) bool {
+	if p.APIKeyID == "" {
+		return true
+	}
+	for _, scope := range scopes {
+		for _, granted := range p.Scopes {
+			if scope == granted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithPrincipal returns a copy of ctx carrying principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// PrincipalFromContext returns the principal of ctx, ok is false for anonymous requests
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// UserIDFromContext returns the user id of the principal of ctx, empty for anonymous requests
+func UserIDFromContext(ctx context.Context) string {
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		return principal.UserID
+	}
+	return ""
+}
+
+// ActorIDFromContext returns the user id of the real caller of ctx, the admin
+// for impersonated requests, empty for anonymous requests
+func ActorIDFromContext(ctx context.Context) string {
+	if principal, ok := PrincipalFromContext(ctx); ok && principal.Actor != nil {
+		return principal.Actor.UserID
+	}
+	return UserIDFromContext(ctx)
+}
+
+// TenantIDFromContext returns the organization the principal of ctx acts in, empty
+// for anonymous requests and callers outside of any organization
+func TenantIDFromContext(ctx context.Context) string {
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		return principal.TenantID
+	}
+	return ""
+}
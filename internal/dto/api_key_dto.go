@@ -0,0 +1,69 @@
+package dto
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// scopes granted to api keys
+const (
+	ScopeFilesRead  = "files:read"
+	ScopeFilesWrite = "files:write"
+	ScopeUsersRead  = "users:read"
+	ScopeUsersAdmin = "users:admin"
+)
+
+// Scopes lists every scope an api key can be granted
+var Scopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeUsersRead, ScopeUsersAdmin}
+
+// APIKeyRequest creates an api key
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// lifetime in seconds, zero for keys which do not expire
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// APIKeyResponse describes an api key, Key is only set once when it is created
+type APIKeyResponse struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Scopes   []string   `json:"scopes"`
+	OrgID    string     `json:"org_id,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+	Revoked  *time.Time `json:"revoked,omitempty"`
+	Created  time.Time  `json:"created"`
+	Key      string     `json:"key,omitempty"`
+}
+
+// ValidateAPIKey validates request
+func (request *APIKeyRequest) ValidateAPIKey() (int, error) {
+	if request.Name == "" {
+		return http.StatusBadRequest, fmt.Errorf("Name is wrong")
+	}
+	if len(request.Scopes) == 0 {
+		return http.StatusBadRequest, fmt.Errorf("Scopes are wrong")
+	}
+	for _, scope := range request.Scopes {
+		if !validScope(scope) {
+			return http.StatusBadRequest, fmt.Errorf("Scope %q is unknown", scope)
+		}
+	}
+	if request.ExpiresIn < 0 {
+		return http.StatusBadRequest, fmt.Errorf("Expiry is wrong")
+	}
+	return http.StatusOK, nil
+}
+
+// validScope reports whether scope is one of Scopes
+func validScope(scope string) bool {
+	for _, known := range Scopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
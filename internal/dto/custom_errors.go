@@ -3,6 +3,7 @@ package dto
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var (
@@ -14,6 +15,27 @@ type ErrorResponse struct {
 	Status  int    `json:"status"`
 	Error   error  `json:"error"`
 	Message string `json:"message"`
+	// Fields lists the reasons of failed validation per request field
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is a reason a request field failed validation
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError is returned by validations reporting reasons per field
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var reasons []string
+	for _, field := range e.Fields {
+		reasons = append(reasons, field.Field+" "+field.Reason)
+	}
+	return strings.Join(reasons, ", ")
 }
 
 func (e ErrorResponse) String() string {
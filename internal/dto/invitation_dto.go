@@ -0,0 +1,93 @@
+package dto
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// InvitationRequest invites a user by email, the invitee becomes a user with Role
+// and joins organization OrgID with OrgRole when one is given
+type InvitationRequest struct {
+	Email   string `json:"email"`
+	Role    string `json:"role"`
+	OrgID   string `json:"org_id"`
+	OrgRole string `json:"org_role"`
+}
+
+// InvitationResponse describes a pending invitation
+type InvitationResponse struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	OrgID     string    `json:"org_id,omitempty"`
+	OrgRole   string    `json:"org_role,omitempty"`
+	InvitedBy string    `json:"invited_by,omitempty"`
+	Expires   time.Time `json:"expires"`
+	Created   time.Time `json:"created"`
+}
+
+// AcceptInvitationRequest request body of an invitee choosing name and password
+type AcceptInvitationRequest struct {
+	Token     string `json:"token"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Password  string `json:"password"`
+}
+
+// JoinInvitationRequest request body of a user accepting an invitation to an organization
+type JoinInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+// ValidateInvitation validates request, invitees become users and members unless roles are given
+func (request *InvitationRequest) ValidateInvitation() (int, error) {
+	request.Email = NormalizeEmail(request.Email)
+	if request.Email == "" {
+		return http.StatusBadRequest, fmt.Errorf("Email is wrong")
+	}
+	if request.Role == "" {
+		request.Role = RoleUser
+	}
+	if request.Role != RoleAdmin && request.Role != RoleUser {
+		return http.StatusBadRequest, fmt.Errorf("Role is wrong")
+	}
+	if request.OrgID == "" {
+		if request.OrgRole != "" {
+			return http.StatusBadRequest, fmt.Errorf("Organization role is wrong")
+		}
+		return http.StatusOK, nil
+	}
+	if request.OrgRole == "" {
+		request.OrgRole = OrgRoleMember
+	}
+	if !validOrgRole(request.OrgRole) {
+		return http.StatusBadRequest, fmt.Errorf("Organization role is wrong")
+	}
+	return http.StatusOK, nil
+}
+
+// ValidateAcceptInvitation validates request
+func (request *AcceptInvitationRequest) ValidateAcceptInvitation() (int, error) {
+	if request.Token == "" {
+		return http.StatusBadRequest, fmt.Errorf("Token is wrong")
+	}
+	if request.FirstName == "" {
+		return http.StatusBadRequest, fmt.Errorf("First Name is wrong")
+	}
+	if request.LastName == "" {
+		return http.StatusBadRequest, fmt.Errorf("Last Name is wrong")
+	}
+	if request.Password == "" {
+		return http.StatusBadRequest, fmt.Errorf("Password is wrong")
+	}
+	return http.StatusOK, nil
+}
+
+// ValidateJoinInvitation validates request
+func (request *JoinInvitationRequest) ValidateJoinInvitation() (int, error) {
+	if request.Token == "" {
+		return http.StatusBadRequest, fmt.Errorf("Token is wrong")
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,94 @@
+package dto
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Roles a member can have in an organization
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// OrganizationRequest request body creating an organization
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+// OrganizationResponse describes an organization and the role of the caller in it
+type OrganizationResponse struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Role    string    `json:"role,omitempty"`
+	Created time.Time `json:"created"`
+	// Current is set for the organization the request acts in
+	Current bool `json:"current"`
+}
+
+// Membership is the role of a user in an organization
+type Membership struct {
+	OrgID  string
+	UserID string
+	Role   string
+}
+
+// MemberRequest request body inviting a user to an organization
+type MemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// MemberRoleRequest request body changing the role of a member
+type MemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// MemberResponse describes a member of an organization
+type MemberResponse struct {
+	UserID    string    `json:"user_id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Created   time.Time `json:"created"`
+}
+
+// ValidateOrganization validates request
+func (request *OrganizationRequest) ValidateOrganization() (int, error) {
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > 100 {
+		return http.StatusBadRequest, fmt.Errorf("Name is wrong")
+	}
+	return http.StatusOK, nil
+}
+
+// ValidateMember validates request, members are invited with the member role unless one is given
+func (request *MemberRequest) ValidateMember() (int, error) {
+	if request.Email == "" {
+		return http.StatusBadRequest, fmt.Errorf("Email is wrong")
+	}
+	if request.Role == "" {
+		request.Role = OrgRoleMember
+	}
+	if !validOrgRole(request.Role) {
+		return http.StatusBadRequest, fmt.Errorf("Role is wrong")
+	}
+	return http.StatusOK, nil
+}
+
+// ValidateMemberRole validates request
+func (request *MemberRoleRequest) ValidateMemberRole() (int, error) {
+	if !validOrgRole(request.Role) {
+		return http.StatusBadRequest, fmt.Errorf("Role is wrong")
+	}
+	return http.StatusOK, nil
+}
+
+// validOrgRole reports whether role is a role members can have
+func validOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin || role == OrgRoleMember
+}
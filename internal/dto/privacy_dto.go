@@ -0,0 +1,52 @@
+package dto
+
+import "time"
+
+// kinds and states of data subject requests
+const (
+	PrivacyRequestExport  = "export"
+	PrivacyRequestErasure = "erasure"
+
+	PrivacyRequestPending   = "pending"
+	PrivacyRequestRunning   = "running"
+	PrivacyRequestCompleted = "completed"
+	PrivacyRequestFailed    = "failed"
+)
+
+// PrivacyRequestResponse describes a data subject request for the data of a user
+type PrivacyRequestResponse struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Kind        string     `json:"kind"`
+	Status      string     `json:"status"`
+	RequestedBy string     `json:"requested_by,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Created     time.Time  `json:"created"`
+	Completed   *time.Time `json:"completed,omitempty"`
+}
+
+// UserExport is the users row of a user in a data export, secrets are left out
+type UserExport struct {
+	ID              string     `json:"id"`
+	FirstName       string     `json:"first_name"`
+	LastName        string     `json:"last_name"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	IsUsing2FA      bool       `json:"is_2fa"`
+	Role            string     `json:"role"`
+	FailedLogins    int        `json:"failed_logins"`
+	LastFailedLogin *time.Time `json:"last_failed_login,omitempty"`
+	Created         *time.Time `json:"created,omitempty"`
+	CreatedBy       string     `json:"created_by,omitempty"`
+	Updated         *time.Time `json:"updated,omitempty"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+}
+
+// DocumentExport describes a document of a user in a data export, its data is a separate file
+type DocumentExport struct {
+	ID       string     `json:"id"`
+	ParentID string     `json:"parent_id"`
+	Name     string     `json:"name"`
+	Created  *time.Time `json:"created,omitempty"`
+	File     string     `json:"file"`
+}
@@ -0,0 +1,15 @@
+package dto
+
+import "time"
+
+// SessionResponse describes a login session, a session lasts as long as its refresh tokens are rotated
+type SessionResponse struct {
+	ID        string    `json:"id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+	Expires   time.Time `json:"expires"`
+	// Current is set for the session of the request
+	Current bool `json:"current"`
+}
@@ -0,0 +1,30 @@
+package dto
+
+// TwoFactorEnrollResponse secret and uri to add to an authenticator app
+type TwoFactorEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TwoFactorCodeRequest code from authenticator app or a recovery code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorLoginRequest second login step request
+type TwoFactorLoginRequest struct {
+	Token string `json:"token"`
+	Code  string `json:"code"`
+}
+
+// TwoFactorChallenge returned by login when a second factor is required
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"2fa_required"`
+	Token             string `json:"token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+// RecoveryCodesResponse one time recovery codes, only shown once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
@@ -3,15 +3,31 @@ package dto
 import (
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
+)
+
+// Roles a user can have
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
 )
 
 // UserRequest Struct
 type UserRequest struct {
-	FirstName  string `json:"first_name"`
-	LastName   string `json:"last_name"`
-	Email      string `json:"email"`
-	Password   string `json:"password"`
-	IsUsing2FA bool   `json:"is_2fa"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+}
+
+// UserUpdateRequest request body replacing the profile of a user, the password
+// and role are changed through their own endpoints and two factor authentication
+// by enrolling an authenticator
+type UserUpdateRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
 }
 
 // UserResponse Struct
@@ -21,36 +37,139 @@ type UserResponse struct {
 	LastName   string `json:"last_name"`
 	Email      string `json:"email"`
 	IsUsing2FA bool   `json:"is_2fa"`
+	Role       string `json:"role,omitempty"`
+	// DeletedAt is only set in listings including deleted users
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 // User db user struct
 type User struct {
-	Email    string `json:"email"`
+	ID         string `json:"-"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	IsUsing2FA bool   `json:"-"`
+	Role       string `json:"-"`
+	// EmailVerified is set once the user followed the verification email
+	EmailVerified bool `json:"-"`
+	// consecutive failed logins, used to throttle password guessing
+	FailedLogins    int       `json:"-"`
+	LastFailedLogin time.Time `json:"-"`
+}
+
+// ForgotPasswordRequest request body for requesting a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// MagicLinkRequest request body for requesting a login link email
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// MagicLinkLoginRequest request body for logging in with the token of a login link
+type MagicLinkLoginRequest struct {
+	Token string `json:"token"`
+}
+
+// ResendVerificationRequest request body for requesting a new verification email
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest request body for setting a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
 	Password string `json:"password"`
 }
 
+// ChangePasswordRequest request body for changing the password of the authenticated user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// RoleRequest request body for changing the role of a user
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
 // JWTToken jwt token
 type JWTToken struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+}
+
+// RefreshTokenRequest request body for token refresh
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// NormalizeEmail returns the form email addresses are stored and compared in,
+// addresses differing only in case belong to the same user
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 // ValidateUser validates request
 func (request *UserRequest) ValidateUser() (int, error) {
 
-	if request.FirstName == "" {
+	if status, err := validateProfile(request.FirstName, request.LastName, request.Email); err != nil {
+		return status, err
+	}
+	if request.Password == "" {
+		return http.StatusBadRequest, fmt.Errorf("Password is wrong")
+	}
+
+	return http.StatusOK, nil
+}
+
+// ValidateUserUpdate validates request with the rules of ValidateUser
+func (request *UserUpdateRequest) ValidateUserUpdate() (int, error) {
+	return validateProfile(request.FirstName, request.LastName, request.Email)
+}
+
+// validateProfile validates the profile fields shared by user requests
+func validateProfile(firstName string, lastName string, email string) (int, error) {
+	if firstName == "" {
 		return http.StatusBadRequest, fmt.Errorf("First Name is wrong")
 	}
-	if request.LastName == "" {
+	if lastName == "" {
 		return http.StatusBadRequest, fmt.Errorf("Last Name is wrong")
 	}
 
-	if request.Email == "" {
+	if email == "" {
 		return http.StatusBadRequest, fmt.Errorf("Email is wrong")
 	}
-	if request.Password == "" {
-		return http.StatusBadRequest, fmt.Errorf("Password is wrong")
+	return http.StatusOK, nil
+}
+
+// ValidateRole validates role request
+func (request *RoleRequest) ValidateRole() (int, error) {
+	if request.Role != RoleAdmin && request.Role != RoleUser {
+		return http.StatusBadRequest, fmt.Errorf("Role is wrong")
 	}
+	return http.StatusOK, nil
+}
 
+// ValidateChangePassword validates request
+func (request *ChangePasswordRequest) ValidateChangePassword() (int, error) {
+	if request.CurrentPassword == "" {
+		return http.StatusBadRequest, fmt.Errorf("Current password is wrong")
+	}
+	if request.NewPassword == "" {
+		return http.StatusBadRequest, fmt.Errorf("New password is wrong")
+	}
 	return http.StatusOK, nil
 }
 
+// ValidateResetPassword validates request
+func (request *ResetPasswordRequest) ValidateResetPassword() (int, error) {
+	if request.Token == "" {
+		return http.StatusBadRequest, fmt.Errorf("Token is wrong")
+	}
+	if request.Password == "" {
+		return http.StatusBadRequest, fmt.Errorf("Password is wrong")
+	}
+	return http.StatusOK, nil
+}
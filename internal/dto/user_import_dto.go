@@ -0,0 +1,38 @@
+package dto
+
+// modes of user imports
+const (
+	// ImportTransactional creates every row or, if one fails, none
+	ImportTransactional = "transactional"
+	// ImportBestEffort creates the valid rows and reports the others
+	ImportBestEffort = "best_effort"
+)
+
+// states of imported rows
+const (
+	ImportRowValid   = "valid"
+	ImportRowCreated = "created"
+	ImportRowFailed  = "failed"
+	// ImportRowSkipped rows were valid but not created because another row failed
+	ImportRowSkipped = "skipped"
+)
+
+// UserImportRow is the result of a row of a user import, Row counts data rows from 1
+type UserImportRow struct {
+	Row    int          `json:"row"`
+	Email  string       `json:"email,omitempty"`
+	Status string       `json:"status"`
+	ID     string       `json:"id,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// UserImportResponse reports the result of every row of a user import
+type UserImportResponse struct {
+	Mode    string          `json:"mode"`
+	DryRun  bool            `json:"dry_run"`
+	Total   int             `json:"total"`
+	Created int             `json:"created"`
+	Failed  int             `json:"failed"`
+	Rows    []UserImportRow `json:"rows"`
+}
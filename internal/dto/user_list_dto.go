@@ -0,0 +1,69 @@
+package dto
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// page sizes of user listings
+const (
+	DefaultUserListLimit = 50
+	MaxUserListLimit     = 200
+)
+
+// UserSortFields lists the fields users can be sorted by
+var UserSortFields = []string{"created", "email", "first_name", "last_name"}
+
+// UserListRequest filters, sorts and pages a user listing
+type UserListRequest struct {
+	// Email and Name match case insensitive parts of the email address and names
+	Email      string
+	Name       string
+	IsUsing2FA *bool
+	// CreatedFrom and CreatedTo bound the creation date, both inclusive
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// IncludeDeleted lists deleted users which were not purged yet
+	IncludeDeleted bool
+	// Sort is one of UserSortFields, prefixed with "-" for descending order
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+// UserListResponse is a page of users, Next links to the following page
+type UserListResponse struct {
+	Data       []UserResponse `json:"data"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	Next       string         `json:"next,omitempty"`
+}
+
+// SortField returns the field to sort by and whether the order is descending
+func (request *UserListRequest) SortField() (string, bool) {
+	if strings.HasPrefix(request.Sort, "-") {
+		return request.Sort[1:], true
+	}
+	return request.Sort, false
+}
+
+// ValidateUserList validates request
+func (request *UserListRequest) ValidateUserList() (int, error) {
+	if request.Limit < 1 || request.Limit > MaxUserListLimit {
+		return http.StatusBadRequest, fmt.Errorf("Limit must be between 1 and %d", MaxUserListLimit)
+	}
+	field, _ := request.SortField()
+	known := false
+	for _, sortField := range UserSortFields {
+		known = known || field == sortField
+	}
+	if !known {
+		return http.StatusBadRequest, fmt.Errorf("Sort field %q is unknown", field)
+	}
+	if request.CreatedFrom != nil && request.CreatedTo != nil && request.CreatedTo.Before(*request.CreatedFrom) {
+		return http.StatusBadRequest, fmt.Errorf("Created range is wrong")
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,57 @@
+// Package mail sends plain text emails. The Sender interface lets the SMTP
+// implementation be replaced, e.g. by a sender talking to an in-process fake server.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Sender sends plain text emails
+type Sender interface {
+	Send(to string, subject string, body string) error
+}
+
+// SMTPSender sends emails through an SMTP server
+type SMTPSender struct {
+	// Addr is the host:port of the server
+	Addr string
+	From string
+	// Auth is nil for servers without authentication
+	Auth smtp.Auth
+}
+
+// NewSMTPSender creates a sender using plain authentication when username is given
+func NewSMTPSender(host string, port string, username string, password string, from string) *SMTPSender {
+	sender := &SMTPSender{Addr: net.JoinHostPort(host, port), From: from}
+	if username != "" {
+		sender.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return sender
+}
+
+// Send sends a plain text email to a single recipient
+func (s *SMTPSender) Send(to string, subject string, body string) error {
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid recipient %q", to)
+	}
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{to}, message(s.From, to, subject, body))
+}
+
+// message builds an RFC 5322 message
+func message(from string, to string, subject string, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", strings.NewReplacer("\r", "", "\n", "").Replace(subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes()
+}
@@ -0,0 +1,45 @@
+// Package mergepatch applies JSON Merge Patch documents (RFC 7386).
+package mergepatch
+
+import (
+	"encoding/json"
+)
+
+// ContentType is the media type of merge patch request bodies
+const ContentType = "application/merge-patch+json"
+
+// Apply merges patch into the json document doc and returns the result. Members
+// of patch replace those of doc, null members remove them and a patch which is
+// not an object replaces the whole document.
+func Apply(doc []byte, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, err
+	}
+	if len(doc) > 0 {
+		if err := json.Unmarshal(doc, &target); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(merge(target, changes))
+}
+
+// merge applies changes to target following the MergePatch function of the rfc
+func merge(target interface{}, changes interface{}) interface{} {
+	patch, ok := changes.(map[string]interface{})
+	if !ok {
+		return changes
+	}
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+	}
+	for name, value := range patch {
+		if value == nil {
+			delete(object, name)
+			continue
+		}
+		object[name] = merge(object[name], value)
+	}
+	return object
+}
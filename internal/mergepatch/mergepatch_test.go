@@ -0,0 +1,66 @@
+package mergepatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestApplyRFC7386(t *testing.T) {
+	// examples of RFC 7386 appendix A
+	tests := []struct {
+		doc, patch, result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		result, err := Apply([]byte(test.doc), []byte(test.patch))
+		if err != nil {
+			t.Errorf("Apply(%s, %s): %v", test.doc, test.patch, err)
+			continue
+		}
+		var got, want interface{}
+		if err = json.Unmarshal(result, &got); err != nil {
+			t.Fatal(err)
+		}
+		if err = json.Unmarshal([]byte(test.result), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Apply(%s, %s) = %s, want %s", test.doc, test.patch, result, test.result)
+		}
+	}
+}
+
+func TestApplyEmptyDocument(t *testing.T) {
+	result, err := Apply(nil, []byte(`{"a":"b","c":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != `{"a":"b"}` {
+		t.Errorf("Apply = %s", result)
+	}
+}
+
+func TestApplyInvalidJSON(t *testing.T) {
+	if _, err := Apply([]byte(`{"a":"b"}`), []byte(`{"a":`)); err == nil {
+		t.Error("invalid patch accepted")
+	}
+	if _, err := Apply([]byte(`{"a":`), []byte(`{"a":"b"}`)); err == nil {
+		t.Error("invalid document accepted")
+	}
+}
@@ -0,0 +1,270 @@
+// Package oidc signs users in with an external OpenID Connect provider using the
+// authorization code flow with PKCE and verifies the ID tokens it returns.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Provider is an OpenID Connect provider the api is registered at as a client
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	Client       *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keyCache
+}
+
+// discovery is the part of the provider configuration document the flow uses
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the claims of a verified ID token
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        Audience `json:"aud"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	NotBefore       int64    `json:"nbf,omitempty"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   bool     `json:"email_verified"`
+	GivenName       string   `json:"given_name"`
+	FamilyName      string   `json:"family_name"`
+}
+
+// clockSkew is tolerated between the provider and the api
+const clockSkew = time.Minute
+
+// Valid checks the time based claims, it is called by the jwt parser
+func (c *Claims) Valid() error {
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return errors.New("id token is expired")
+	}
+	if c.NotBefore != 0 && now.Before(time.Unix(c.NotBefore, 0).Add(-clockSkew)) {
+		return errors.New("id token is not valid yet")
+	}
+	return nil
+}
+
+// Audience is the aud claim, a single string or an array of strings
+type Audience []string
+
+// UnmarshalJSON accepts both forms of the aud claim
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// Contains reports whether clientID is an audience
+func (a Audience) Contains(clientID string) bool {
+	for _, audience := range a {
+		if audience == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// NewProvider creates a provider, its configuration is discovered on first use
+func NewProvider(issuer string, clientID string, clientSecret string, redirectURL string, scopes []string) *Provider {
+	return &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// AuthCodeURL returns the url the user is redirected to for signing in
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	config, err := p.configuration(ctx)
+	if err != nil {
+		return "", err
+	}
+	authURL, err := url.Parse(config.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the claims of the verified ID token
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Claims, error) {
+	config, err := p.configuration(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = p.doJSON(request, &token); err != nil && token.Error == "" {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("token request: %s", strings.TrimSpace(token.Error+" "+token.ErrorDescription))
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID token
+func (p *Provider) Verify(ctx context.Context, idToken string, nonce string) (*Claims, error) {
+	config, err := p.configuration(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return p.keys.key(ctx, token)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("id token: %w", err)
+	}
+	if claims.Issuer != config.Issuer {
+		return nil, fmt.Errorf("id token issued by %q", claims.Issuer)
+	}
+	if !claims.Audience.Contains(p.ClientID) {
+		return nil, errors.New("id token is not issued for this client")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return nil, errors.New("id token is authorized for another party")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("id token nonce does not match")
+	}
+	return claims, nil
+}
+
+// configuration discovers the provider configuration once
+func (p *Provider) configuration(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	config := &discovery{}
+	if err = p.doJSON(request, config); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", config.Issuer, p.Issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: incomplete provider configuration")
+	}
+	p.discovery = config
+	p.keys = &keyCache{url: config.JWKSURI, fetch: p.doJSON}
+	return config, nil
+}
+
+// doJSON sends request and decodes the json response into v, the body is
+// decoded for error responses too
+func (p *Provider) doJSON(request *http.Request, v interface{}) error {
+	response, err := p.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	decodeErr := json.Unmarshal(body, v)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return decodeErr
+}
+
+// RandomString creates a random url safe string for state, nonce and pkce verifiers
+func RandomString() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Challenge derives the S256 pkce code challenge of verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,284 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	testClientID     = "api"
+	testClientSecret = "client secret"
+	testRedirectURL  = "https://api.example.com/auth/oidc/callback"
+)
+
+// stubIssuer is an OpenID Connect provider serving discovery, keys and a token
+// endpoint answering every code with an id token of claims signed by key
+type stubIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	// sign signs the id token, by default with key as kid "rsa"
+	sign   func(claims jwt.MapClaims) string
+	claims jwt.MapClaims
+	// tokenForm is the form of the last token request
+	tokenForm url.Values
+	tokenAuth [2]string
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubIssuer{key: key, ecKey: ecKey}
+	s.sign = func(claims jwt.MapClaims) string {
+		return s.signWith(t, jwt.SigningMethodRS256, "rsa", s.key, claims)
+	}
+
+	mux := http.NewServeMux()
+	discovery := func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(map[string]string{
+			"issuer":                 s.server.URL,
+			"authorization_endpoint": s.server.URL + "/authorize",
+			"token_endpoint":         s.server.URL + "/token",
+			"jwks_uri":               s.server.URL + "/keys",
+		})
+	}
+	mux.HandleFunc("/.well-known/openid-configuration", discovery)
+	// a tenant path serving the document of the issuer itself
+	mux.HandleFunc("/tenant/.well-known/openid-configuration", discovery)
+	mux.HandleFunc("/keys", func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(map[string][]map[string]string{"keys": {
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "alg": "RS256",
+				"n": jwt.EncodeSegment(key.N.Bytes()), "e": jwt.EncodeSegment(big.NewInt(int64(key.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "use": "sig", "alg": "ES256", "crv": "P-256",
+				"x": jwt.EncodeSegment(ecKey.X.Bytes()), "y": jwt.EncodeSegment(ecKey.Y.Bytes())},
+			{"kty": "RSA", "kid": "enc", "use": "enc",
+				"n": jwt.EncodeSegment(key.N.Bytes()), "e": jwt.EncodeSegment(big.NewInt(int64(key.E)).Bytes())},
+		}})
+	})
+	mux.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		request.ParseForm()
+		s.tokenForm = request.PostForm
+		s.tokenAuth[0], s.tokenAuth[1], _ = request.BasicAuth()
+		if request.PostForm.Get("code") != "good code" {
+			writer.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(writer).Encode(map[string]string{"error": "invalid_grant", "error_description": "code expired"})
+			return
+		}
+		json.NewEncoder(writer).Encode(map[string]string{"access_token": "opaque", "id_token": s.sign(s.claims)})
+	})
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+
+	now := time.Now()
+	s.claims = jwt.MapClaims{
+		"iss":            s.server.URL,
+		"sub":            "subject-1",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          "the nonce",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"given_name":     "Jane",
+		"family_name":    "Doe",
+	}
+	return s
+}
+
+// signWith signs claims with method and key under kid
+func (s *stubIssuer) signWith(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// provider returns a client of the issuer
+func (s *stubIssuer) provider() *Provider {
+	provider := NewProvider(s.server.URL+"/", testClientID, testClientSecret, testRedirectURL, []string{"openid", "email"})
+	provider.Client = s.server.Client()
+	return provider
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	issuer := newStubIssuer(t)
+	authURL, err := issuer.provider().AuthCodeURL(context.Background(), "the state", "the nonce", "the verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, issuer.server.URL+"/authorize?") {
+		t.Errorf("url %s does not point to the authorization endpoint", authURL)
+	}
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email",
+		"state":                 "the state",
+		"nonce":                 "the nonce",
+		"code_challenge":        Challenge("the verifier"),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := parsed.Query().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	// example of RFC 7636 appendix B
+	if challenge := Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Challenge = %s", challenge)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newStubIssuer(t)
+	claims, err := issuer.provider().Exchange(context.Background(), "good code", "the verifier", "the nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "subject-1" || claims.Email != "jane@example.com" || !claims.EmailVerified || claims.GivenName != "Jane" || claims.FamilyName != "Doe" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	form := issuer.tokenForm
+	if form.Get("grant_type") != "authorization_code" || form.Get("code") != "good code" ||
+		form.Get("code_verifier") != "the verifier" || form.Get("redirect_uri") != testRedirectURL {
+		t.Errorf("token request form = %v", form)
+	}
+	// client credentials are form encoded before basic authentication, RFC 6749 section 2.3.1
+	if issuer.tokenAuth != [2]string{testClientID, url.QueryEscape(testClientSecret)} {
+		t.Errorf("token request credentials = %q", issuer.tokenAuth)
+	}
+}
+
+func TestExchangeAcceptsECKeysAndAudienceLists(t *testing.T) {
+	issuer := newStubIssuer(t)
+	issuer.claims["aud"] = []string{testClientID, "other"}
+	issuer.claims["azp"] = testClientID
+	issuer.sign = func(claims jwt.MapClaims) string {
+		return issuer.signWith(t, jwt.SigningMethodES256, "ec", issuer.ecKey, claims)
+	}
+	if _, err := issuer.provider().Exchange(context.Background(), "good code", "the verifier", "the nonce"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExchangeRejectsInvalidIDTokens(t *testing.T) {
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		change func(issuer *stubIssuer)
+		err    string
+	}{
+		{"nonce mismatch", func(issuer *stubIssuer) { issuer.claims["nonce"] = "other nonce" }, "nonce does not match"},
+		{"missing nonce", func(issuer *stubIssuer) { delete(issuer.claims, "nonce") }, "nonce does not match"},
+		{"wrong aud", func(issuer *stubIssuer) { issuer.claims["aud"] = "other client" }, "not issued for this client"},
+		{"aud list without azp", func(issuer *stubIssuer) { issuer.claims["aud"] = []string{testClientID, "other"} }, "authorized for another party"},
+		{"wrong issuer", func(issuer *stubIssuer) { issuer.claims["iss"] = "https://evil.example.com" }, "issued by"},
+		{"expired", func(issuer *stubIssuer) { issuer.claims["exp"] = time.Now().Add(-2 * clockSkew).Unix() }, "expired"},
+		{"no expiry", func(issuer *stubIssuer) { delete(issuer.claims, "exp") }, "expired"},
+		{"not yet valid", func(issuer *stubIssuer) { issuer.claims["nbf"] = time.Now().Add(2 * clockSkew).Unix() }, "not valid yet"},
+		{"no subject", func(issuer *stubIssuer) { delete(issuer.claims, "sub") }, "no subject"},
+		{"unknown key", func(issuer *stubIssuer) {
+			issuer.sign = func(claims jwt.MapClaims) string {
+				return issuer.signWith(t, jwt.SigningMethodRS256, "rotated", other, claims)
+			}
+		}, "unknown key id"},
+		{"encryption key", func(issuer *stubIssuer) {
+			issuer.sign = func(claims jwt.MapClaims) string {
+				return issuer.signWith(t, jwt.SigningMethodRS256, "enc", issuer.key, claims)
+			}
+		}, "unknown key id"},
+		{"wrong signature", func(issuer *stubIssuer) {
+			issuer.sign = func(claims jwt.MapClaims) string {
+				return issuer.signWith(t, jwt.SigningMethodRS256, "rsa", other, claims)
+			}
+		}, "verification error"},
+		{"shared secret", func(issuer *stubIssuer) {
+			issuer.sign = func(claims jwt.MapClaims) string {
+				return issuer.signWith(t, jwt.SigningMethodHS256, "rsa", []byte(testClientSecret), claims)
+			}
+		}, "unexpected signing method"},
+		{"rsa key with ec algorithm", func(issuer *stubIssuer) {
+			issuer.sign = func(claims jwt.MapClaims) string {
+				return issuer.signWith(t, jwt.SigningMethodES256, "rsa", issuer.ecKey, claims)
+			}
+		}, "unexpected signing method"},
+	}
+	for _, test := range tests {
+		issuer := newStubIssuer(t)
+		test.change(issuer)
+		_, err := issuer.provider().Exchange(context.Background(), "good code", "the verifier", "the nonce")
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: err = %v, want it to contain %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestExchangeReportsProviderErrors(t *testing.T) {
+	issuer := newStubIssuer(t)
+	_, err := issuer.provider().Exchange(context.Background(), "bad code", "the verifier", "the nonce")
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant code expired") {
+		t.Errorf("err = %v, want the provider error", err)
+	}
+}
+
+func TestDiscoveryRejectsOtherIssuer(t *testing.T) {
+	issuer := newStubIssuer(t)
+	provider := NewProvider(issuer.server.URL+"/tenant", testClientID, "", testRedirectURL, nil)
+	provider.Client = issuer.server.Client()
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("discovery document of another issuer: err = %v", err)
+	}
+}
+
+func TestAudienceUnmarshal(t *testing.T) {
+	for input, want := range map[string]Audience{`"api"`: {"api"}, `["api","other"]`: {"api", "other"}} {
+		var audience Audience
+		if err := json.Unmarshal([]byte(input), &audience); err != nil {
+			t.Fatal(err)
+		}
+		if len(audience) != len(want) || !audience.Contains("api") {
+			t.Errorf("%s: audience = %q", input, audience)
+		}
+	}
+	var audience Audience
+	if err := json.Unmarshal([]byte(`42`), &audience); err == nil {
+		t.Error("numeric audience accepted")
+	}
+}
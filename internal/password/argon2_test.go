@@ -0,0 +1,118 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testArgon2id uses small parameters to keep the tests fast
+var testArgon2id = Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idHashAndVerify(t *testing.T) {
+	hash, err := testArgon2id.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("hash %s is not a PHC string of the configured parameters", hash)
+	}
+	if !testArgon2id.Recognizes(hash) {
+		t.Error("own hash not recognized")
+	}
+
+	other, err := testArgon2id.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Error("hashes of the same password are equal, salt missing")
+	}
+
+	if ok, err := testArgon2id.Verify(hash, "correct horse"); !ok || err != nil {
+		t.Errorf("Verify(right password) = %v, %v", ok, err)
+	}
+	if ok, err := testArgon2id.Verify(hash, "wrong horse"); ok || err != nil {
+		t.Errorf("Verify(wrong password) = %v, %v", ok, err)
+	}
+}
+
+func TestArgon2idVerifyUsesStoredParameters(t *testing.T) {
+	stronger := testArgon2id
+	stronger.Iterations = 2
+	stronger.KeyLength = 16
+	hash, err := stronger.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := testArgon2id.Verify(hash, "correct horse"); !ok || err != nil {
+		t.Errorf("Verify of hash with other parameters = %v, %v", ok, err)
+	}
+}
+
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	valid, err := testArgon2id.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, "$")
+	salt, key := parts[4], parts[5]
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"bcrypt", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"},
+		{"argon2i", "$argon2i$v=19$m=1024,t=1,p=1$" + salt + "$" + key},
+		{"missing part", "$argon2id$v=19$m=1024,t=1,p=1$" + salt},
+		{"old version", "$argon2id$v=16$m=1024,t=1,p=1$" + salt + "$" + key},
+		{"bad version", "$argon2id$version$m=1024,t=1,p=1$" + salt + "$" + key},
+		{"bad parameters", "$argon2id$v=19$m=1024,p=1$" + salt + "$" + key},
+		{"no iterations", "$argon2id$v=19$m=1024,t=0,p=1$" + salt + "$" + key},
+		{"no parallelism", "$argon2id$v=19$m=1024,t=1,p=0$" + salt + "$" + key},
+		{"bad salt", "$argon2id$v=19$m=1024,t=1,p=1$not base64!$" + key},
+		{"bad key", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$not base64!"},
+		{"empty key", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$"},
+	}
+	for _, test := range tests {
+		if ok, err := testArgon2id.Verify(test.hash, "correct horse"); ok || err == nil {
+			t.Errorf("%s: Verify = %v, %v, want an error", test.name, ok, err)
+		}
+		if !testArgon2id.NeedsRehash(test.hash) {
+			t.Errorf("%s: NeedsRehash = false", test.name)
+		}
+	}
+	if _, err := testArgon2id.Verify(tests[0].hash, "x"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("bcrypt hash: err = %v, want ErrUnknownHash", err)
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	hash, err := testArgon2id.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testArgon2id.NeedsRehash(hash) {
+		t.Error("hash with the configured parameters needs rehash")
+	}
+
+	weaker := testArgon2id
+	weaker.Memory = 512
+	if weaker.NeedsRehash(hash) {
+		t.Error("hash with stronger parameters needs rehash")
+	}
+
+	for name, change := range map[string]func(*Argon2id){
+		"memory":      func(a *Argon2id) { a.Memory *= 2 },
+		"iterations":  func(a *Argon2id) { a.Iterations++ },
+		"parallelism": func(a *Argon2id) { a.Parallelism++ },
+		"salt length": func(a *Argon2id) { a.SaltLength *= 2 },
+		"key length":  func(a *Argon2id) { a.KeyLength *= 2 },
+	} {
+		stronger := testArgon2id
+		change(&stronger)
+		if !stronger.NeedsRehash(hash) {
+			t.Errorf("hash with lower %s does not need rehash", name)
+		}
+	}
+}
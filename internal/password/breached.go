@@ -0,0 +1,76 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	_ "embed"
+)
+
+// bundledBreached lists uppercase hex SHA-1 hashes of common and breached passwords, one per line
+//go:embed breached.txt
+var bundledBreached string
+
+// prefixLength is the number of hex digits a range is looked up by, like the
+// k-anonymity range API of Have I Been Pwned
+const prefixLength = 5
+
+// BreachedList answers whether a password is known to be breached by looking up
+// the range of hash suffixes sharing the first hex digits of its SHA-1
+type BreachedList struct {
+	ranges map[string]map[string]struct{}
+}
+
+// BundledBreachedList returns the list shipped with the binary
+func BundledBreachedList() (*BreachedList, error) {
+	return ReadBreachedList(strings.NewReader(bundledBreached))
+}
+
+// LoadBreachedList reads a list of SHA-1 hashes from path, lines may carry a
+// ":count" suffix as in downloaded Have I Been Pwned files
+func LoadBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadBreachedList(file)
+}
+
+// ReadBreachedList reads a list of SHA-1 hashes, one per line
+func ReadBreachedList(reader io.Reader) (*BreachedList, error) {
+	list := &BreachedList{ranges: map[string]map[string]struct{}{}}
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		hash := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(hash, ':'); i >= 0 {
+			hash = hash[:i]
+		}
+		if hash == "" || strings.HasPrefix(hash, "#") {
+			continue
+		}
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("breached password list line %d: invalid sha1 hash", line)
+		}
+		hash = strings.ToUpper(hash)
+		prefix := hash[:prefixLength]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = map[string]struct{}{}
+		}
+		list.ranges[prefix][hash[prefixLength:]] = struct{}{}
+	}
+	return list, scanner.Err()
+}
+
+// Contains reports whether password is in the list
+func (l *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, ok := l.ranges[hash[:prefixLength]][hash[prefixLength:]]
+	return ok
+}
@@ -0,0 +1,68 @@
+package password
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundledBreachedList(t *testing.T) {
+	list, err := BundledBreachedList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"password", "123456", "qwerty"} {
+		if !list.Contains(password) {
+			t.Errorf("bundled list does not contain %q", password)
+		}
+	}
+	if list.Contains("Correct-Horse-42") {
+		t.Error("bundled list contains an uncommon password")
+	}
+}
+
+func TestReadBreachedList(t *testing.T) {
+	// sha1 of "password" in lowercase with a count, of "letmein" in uppercase
+	input := "# comment\n\n5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8:3861493\n  B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3  \n"
+	list, err := ReadBreachedList(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Contains("password") || !list.Contains("letmein") {
+		t.Error("listed passwords not found")
+	}
+	if list.Contains("Password") {
+		t.Error("unlisted password found")
+	}
+}
+
+func TestReadBreachedListRejectsInvalidLines(t *testing.T) {
+	for _, input := range []string{
+		"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd\n",
+		"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8aa\n",
+		"zbaa61e4c9b93f3f0682250b6cf8331b7ee68fd8\n",
+		"password\n",
+	} {
+		if _, err := ReadBreachedList(strings.NewReader(input)); err == nil {
+			t.Errorf("invalid line %q accepted", strings.TrimSpace(input))
+		}
+	}
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := ioutil.WriteFile(path, []byte("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Contains("password") {
+		t.Error("password from file not found")
+	}
+	if _, err = LoadBreachedList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
@@ -0,0 +1,68 @@
+// Package password hashes and verifies passwords with bcrypt or argon2id and
+// tells when a stored hash was made with weaker parameters than configured.
+package password
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownHash is returned for hashes no hasher recognizes
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Hasher hashes passwords with one algorithm
+type Hasher interface {
+	// Hash hashes password with a random salt
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash
+	Verify(hash string, password string) (bool, error)
+	// Recognizes reports whether hash was made by this algorithm
+	Recognizes(hash string) bool
+	// NeedsRehash reports whether hash was made with weaker parameters than the hasher uses
+	NeedsRehash(hash string) bool
+}
+
+// Hashers hashes new passwords with its first hasher and verifies hashes of any of them
+type Hashers []Hasher
+
+// New creates hashers preferring algorithm, either "bcrypt" or "argon2id"
+func New(algorithm string, bcrypt Bcrypt, argon2 Argon2id) (Hashers, error) {
+	switch algorithm {
+	case "bcrypt":
+		return Hashers{bcrypt, argon2}, nil
+	case "argon2id", "":
+		return Hashers{argon2, bcrypt}, nil
+	}
+	return nil, fmt.Errorf("unsupported password hashing algorithm %q", algorithm)
+}
+
+// Hash hashes password with the preferred hasher
+func (h Hashers) Hash(password string) (string, error) {
+	return h[0].Hash(password)
+}
+
+// Verify reports whether password matches hash made by any of the hashers
+func (h Hashers) Verify(hash string, password string) (bool, error) {
+	for _, hasher := range h {
+		if hasher.Recognizes(hash) {
+			return hasher.Verify(hash, password)
+		}
+	}
+	return false, ErrUnknownHash
+}
+
+// Recognizes reports whether any of the hashers made hash
+func (h Hashers) Recognizes(hash string) bool {
+	for _, hasher := range h {
+		if hasher.Recognizes(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRehash reports whether hash was made by another than the preferred
+// hasher or with weaker parameters
+func (h Hashers) NeedsRehash(hash string) bool {
+	return !h[0].Recognizes(hash) || h[0].NeedsRehash(hash)
+}
@@ -0,0 +1,96 @@
+package password
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNewPrefersAlgorithm(t *testing.T) {
+	bcryptHasher := Bcrypt{Cost: bcrypt.MinCost}
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{"bcrypt", "$2a$"},
+		{"argon2id", "$argon2id$"},
+		{"", "$argon2id$"},
+	}
+	for _, test := range tests {
+		hashers, err := New(test.algorithm, bcryptHasher, testArgon2id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := hashers.Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash[:len(test.prefix)] != test.prefix {
+			t.Errorf("%q: hash %s, want prefix %s", test.algorithm, hash, test.prefix)
+		}
+	}
+	if _, err := New("md5", bcryptHasher, testArgon2id); err == nil {
+		t.Error("unsupported algorithm accepted")
+	}
+}
+
+func TestHashersVerifyBothAlgorithms(t *testing.T) {
+	bcryptHasher := Bcrypt{Cost: bcrypt.MinCost}
+	hashers, err := New("argon2id", bcryptHasher, testArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := bcryptHasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := hashers.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hash := range []string{legacy, current} {
+		if ok, err := hashers.Verify(hash, "correct horse"); !ok || err != nil {
+			t.Errorf("Verify(%s) = %v, %v", hash, ok, err)
+		}
+		if ok, _ := hashers.Verify(hash, "wrong horse"); ok {
+			t.Errorf("Verify(%s) accepted a wrong password", hash)
+		}
+	}
+	if _, err = hashers.Verify("plain text", "plain text"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("Verify of unknown hash: err = %v, want ErrUnknownHash", err)
+	}
+	if hashers.Recognizes("plain text") {
+		t.Error("unknown hash recognized")
+	}
+}
+
+func TestHashersNeedsRehash(t *testing.T) {
+	bcryptHasher := Bcrypt{Cost: bcrypt.MinCost}
+	hashers, err := New("argon2id", bcryptHasher, testArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := bcryptHasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := hashers.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hashers.NeedsRehash(legacy) {
+		t.Error("hash of the other algorithm does not need rehash")
+	}
+	if hashers.NeedsRehash(current) {
+		t.Error("hash of the preferred algorithm needs rehash")
+	}
+
+	if (Bcrypt{Cost: bcrypt.MinCost}).NeedsRehash(legacy) {
+		t.Error("bcrypt hash of the configured cost needs rehash")
+	}
+	if !(Bcrypt{Cost: bcrypt.MinCost + 1}).NeedsRehash(legacy) {
+		t.Error("bcrypt hash of a lower cost does not need rehash")
+	}
+}
@@ -0,0 +1,77 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Policy describes what passwords are accepted
+type Policy struct {
+	MinLength     int
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// rejects passwords containing the email address or its local part
+	DisallowEmail bool
+	// Breached is nil when passwords are not checked against a breached list
+	Breached *BreachedList
+}
+
+// Check returns the reasons password is not accepted for the account email, none if it is
+func (p *Policy) Check(password string, email string) []string {
+	var reasons []string
+	if len([]rune(password)) < p.MinLength {
+		reasons = append(reasons, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireLower && !lower {
+		reasons = append(reasons, "must contain a lowercase letter")
+	}
+	if p.RequireUpper && !upper {
+		reasons = append(reasons, "must contain an uppercase letter")
+	}
+	if p.RequireDigit && !digit {
+		reasons = append(reasons, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		reasons = append(reasons, "must contain a symbol")
+	}
+
+	if p.DisallowEmail && containsEmail(password, email) {
+		reasons = append(reasons, "must not contain the email address")
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		reasons = append(reasons, "is too common or appeared in a data breach")
+	}
+	return reasons
+}
+
+// containsEmail reports whether password contains email or its local part, ignoring case
+func containsEmail(password string, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	local := email
+	if i := strings.LastIndexByte(email, '@'); i >= 0 {
+		local = email[:i]
+	}
+	// very short local parts would reject too many passwords
+	if len(local) < 3 {
+		return email != "" && strings.Contains(password, email)
+	}
+	return strings.Contains(password, local)
+}
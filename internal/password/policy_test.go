@@ -0,0 +1,75 @@
+package password
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	breached, err := BundledBreachedList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{MinLength: 10, RequireLower: true, RequireUpper: true, RequireDigit: true, RequireSymbol: true, DisallowEmail: true, Breached: breached}
+
+	tests := []struct {
+		password string
+		email    string
+		reasons  []string
+	}{
+		{"Correct-Horse-42", "jane@example.com", nil},
+		{"Ab1-", "jane@example.com", []string{"must be at least 10 characters long"}},
+		{"CORRECT-HORSE-42", "jane@example.com", []string{"must contain a lowercase letter"}},
+		{"correct-horse-42", "jane@example.com", []string{"must contain an uppercase letter"}},
+		{"Correct-Horse-XL", "jane@example.com", []string{"must contain a digit"}},
+		{"CorrectHorse42", "jane@example.com", []string{"must contain a symbol"}},
+		{"Jane.Doe-Horse-42", "jane.doe@example.com", []string{"must not contain the email address"}},
+		{"password", "jane@example.com", []string{
+			"must be at least 10 characters long",
+			"must contain an uppercase letter",
+			"must contain a digit",
+			"must contain a symbol",
+			"is too common or appeared in a data breach",
+		}},
+	}
+	for _, test := range tests {
+		if reasons := policy.Check(test.password, test.email); !reflect.DeepEqual(reasons, test.reasons) {
+			t.Errorf("Check(%q) = %q, want %q", test.password, reasons, test.reasons)
+		}
+	}
+}
+
+func TestPolicyCountsCharacters(t *testing.T) {
+	policy := &Policy{MinLength: 4}
+	if reasons := policy.Check("äöüß", ""); reasons != nil {
+		t.Errorf("four letters rejected: %q", reasons)
+	}
+}
+
+func TestPolicyWithoutRequirements(t *testing.T) {
+	policy := &Policy{}
+	if reasons := policy.Check("password", "password@example.com"); reasons != nil {
+		t.Errorf("empty policy rejected password: %q", reasons)
+	}
+}
+
+func TestContainsEmail(t *testing.T) {
+	tests := []struct {
+		password string
+		email    string
+		contains bool
+	}{
+		{"my-JANE-password", "jane@example.com", true},
+		{"janet", "jane@example.com", true},
+		{"jan-password", "jane@example.com", false},
+		// short local parts only match the whole address
+		{"joe-password", "jo@example.com", false},
+		{"Jo@Example.com!", "jo@example.com", true},
+		{"password", "", false},
+	}
+	for _, test := range tests {
+		if contains := containsEmail(test.password, test.email); contains != test.contains {
+			t.Errorf("containsEmail(%q, %q) = %v, want %v", test.password, test.email, contains, test.contains)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Package signing signs and verifies tokens with a set of keys identified by kid,
+// allowing signing keys to be rotated while older keys still verify.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Key is a key used to sign or verify tokens
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// private is nil for keys that only verify tokens
+	private interface{}
+	public  interface{}
+}
+
+// KeySet signs with its active key and verifies with any of its keys
+type KeySet struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is the JSON Web Key Set document
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewHMACKey creates a shared secret key, it is never published in the key set document
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Method: jwt.SigningMethodHS256, private: secret, public: secret}
+}
+
+// LoadKey loads a key from PEM files, privateKeyPath is empty for keys that only verify tokens
+func LoadKey(id string, algorithm string, privateKeyPath string, publicKeyPath string) (*Key, error) {
+	method := jwt.GetSigningMethod(algorithm)
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *SigningMethodEdDSA:
+	default:
+		return nil, fmt.Errorf("key %s: unsupported algorithm %q", id, algorithm)
+	}
+
+	key := &Key{ID: id, Method: method}
+	if privateKeyPath != "" {
+		private, err := readPrivateKey(privateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		switch private := private.(type) {
+		case *rsa.PrivateKey:
+			key.private, key.public = private, &private.PublicKey
+		case ed25519.PrivateKey:
+			key.private, key.public = private, private.Public()
+		default:
+			return nil, fmt.Errorf("key %s: unsupported private key type %T", id, private)
+		}
+	} else if publicKeyPath != "" {
+		public, err := readPublicKey(publicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		key.public = public
+	} else {
+		return nil, fmt.Errorf("key %s: neither private nor public key given", id)
+	}
+
+	// reject keys that do not match the configured algorithm
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		if _, ok := method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("key %s: rsa key can not be used with %s", id, algorithm)
+		}
+	case ed25519.PublicKey:
+		if method != EdDSA {
+			return nil, fmt.Errorf("key %s: ed25519 key can not be used with %s", id, algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("key %s: unsupported public key type %T", id, key.public)
+	}
+	return key, nil
+}
+
+// NewKeySet creates a key set signing with the key activeID
+func NewKeySet(activeID string, keys ...*Key) (*KeySet, error) {
+	set := &KeySet{keys: map[string]*Key{}}
+	for _, key := range keys {
+		if _, ok := set.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		set.keys[key.ID] = key
+	}
+
+	active, ok := set.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q is not configured", activeID)
+	}
+	if active.private == nil {
+		return nil, fmt.Errorf("active key %q has no private key", activeID)
+	}
+	set.active = active
+	return set, nil
+}
+
+// Sign signs claims with the active key
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.active.Method, claims)
+	if s.active.ID != "" {
+		token.Header["kid"] = s.active.ID
+	}
+	return token.SignedString(s.active.private)
+}
+
+// Keyfunc resolves the verification key of token by its kid header
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// JWKS returns the public keys of the set, shared secrets are left out
+func (s *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range s.keys {
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   jwt.EncodeSegment(public.N.Bytes()),
+				E:   jwt.EncodeSegment(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   jwt.EncodeSegment(public),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// readPrivateKey reads a PKCS#1 or PKCS#8 PEM encoded private key
+func readPrivateKey(path string) (interface{}, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// readPublicKey reads a PKIX or PKCS#1 PEM encoded public key
+func readPublicKey(path string) (interface{}, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// readPEM reads the first PEM block of path
+func readPEM(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	return block, nil
+}
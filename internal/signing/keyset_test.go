@@ -0,0 +1,257 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// writePEM writes a PEM block of blockType to a file in dir and returns its path
+func writePEM(t *testing.T, dir string, name string, blockType string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// rsaKeyFiles creates an rsa key pair and returns the paths of the private and public key
+func rsaKeyFiles(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(private)),
+		writePEM(t, dir, "rsa.pub", "PUBLIC KEY", public)
+}
+
+// ed25519KeyFile creates an ed25519 private key and returns its path
+func ed25519KeyFile(t *testing.T, dir string) string {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, dir, "ed25519.pem", "PRIVATE KEY", data)
+}
+
+// parse verifies token with set
+func parse(set *KeySet, token string) error {
+	_, err := jwt.ParseWithClaims(token, &jwt.StandardClaims{}, set.Keyfunc)
+	return err
+}
+
+func TestSignAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	rsaPrivate, _ := rsaKeyFiles(t, dir)
+	rsaKey, err := LoadKey("rsa", "RS256", rsaPrivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := LoadKey("ed", "EdDSA", ed25519KeyFile(t, dir), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, active := range []string{"rsa", "ed"} {
+		set, err := NewKeySet(active, rsaKey, edKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := set.Sign(jwt.StandardClaims{Subject: "user"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, &jwt.StandardClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Header["kid"] != active {
+			t.Errorf("kid = %v, want %s", parsed.Header["kid"], active)
+		}
+		if err = parse(set, token); err != nil {
+			t.Errorf("%s: token does not verify: %v", active, err)
+		}
+	}
+}
+
+func TestKeyfuncRejectsAlgorithmConfusion(t *testing.T) {
+	dir := t.TempDir()
+	rsaPrivate, rsaPublic := rsaKeyFiles(t, dir)
+	signer, err := LoadKey("rsa", "RS256", rsaPrivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := NewKeySet("rsa", signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an HS256 token using the published public key as secret must not verify
+	public, err := ioutil.ReadFile(rsaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "admin"})
+	forged.Header["kid"] = "rsa"
+	token, err := forged.SignedString(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = parse(set, token); err == nil || !strings.Contains(err.Error(), "unexpected signing method") {
+		t.Errorf("HS256 token with rsa kid: err = %v", err)
+	}
+
+	// the same holds for RS512 with the right key
+	other := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.StandardClaims{Subject: "admin"})
+	other.Header["kid"] = "rsa"
+	if token, err = other.SignedString(signer.private); err != nil {
+		t.Fatal(err)
+	}
+	if err = parse(set, token); err == nil || !strings.Contains(err.Error(), "unexpected signing method") {
+		t.Errorf("RS512 token with RS256 kid: err = %v", err)
+	}
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.StandardClaims{Subject: "admin"})
+	unsigned.Header["kid"] = "rsa"
+	if token, err = unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType); err != nil {
+		t.Fatal(err)
+	}
+	if err = parse(set, token); err == nil {
+		t.Error("unsigned token verified")
+	}
+}
+
+func TestKeyfuncRejectsUnknownKid(t *testing.T) {
+	set, err := NewKeySet("current", NewHMACKey("current", []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kid := range []interface{}{"other", nil, 42} {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "user"})
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = parse(set, signed); err == nil || !strings.Contains(err.Error(), "unknown key id") {
+			t.Errorf("kid %v: err = %v", kid, err)
+		}
+	}
+}
+
+func TestKeyfuncAcceptsRotatedKeys(t *testing.T) {
+	dir := t.TempDir()
+	_, rsaPublic := rsaKeyFiles(t, dir)
+	old, err := LoadKey("old", "RS256", "", rsaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := NewKeySet("legacy", NewHMACKey("legacy", []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := before.Sign(jwt.StandardClaims{Subject: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edKey, err := LoadKey("new", "EdDSA", ed25519KeyFile(t, dir), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewKeySet("new", edKey, old, NewHMACKey("legacy", []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = parse(after, token); err != nil {
+		t.Errorf("token of the retired key does not verify: %v", err)
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	dir := t.TempDir()
+	_, rsaPublic := rsaKeyFiles(t, dir)
+	verifyOnly, err := LoadKey("public", "RS256", "", rsaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacKey := NewHMACKey("hmac", []byte("secret"))
+
+	if _, err = NewKeySet("hmac", hmacKey, NewHMACKey("hmac", []byte("other"))); err == nil {
+		t.Error("duplicate key id accepted")
+	}
+	if _, err = NewKeySet("missing", hmacKey); err == nil {
+		t.Error("missing active key accepted")
+	}
+	if _, err = NewKeySet("public", verifyOnly, hmacKey); err == nil {
+		t.Error("active key without private key accepted")
+	}
+}
+
+func TestLoadKeyRejectsMismatchedAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	rsaPrivate, _ := rsaKeyFiles(t, dir)
+	tests := []struct {
+		algorithm string
+		path      string
+	}{
+		{"EdDSA", rsaPrivate},
+		{"RS256", ed25519KeyFile(t, dir)},
+		{"HS256", rsaPrivate},
+		{"none", rsaPrivate},
+	}
+	for _, test := range tests {
+		if _, err := LoadKey("key", test.algorithm, test.path, ""); err == nil {
+			t.Errorf("%s key loaded from %s", test.algorithm, filepath.Base(test.path))
+		}
+	}
+}
+
+func TestJWKSLeavesOutSharedSecrets(t *testing.T) {
+	dir := t.TempDir()
+	rsaPrivate, _ := rsaKeyFiles(t, dir)
+	rsaKey, err := LoadKey("rsa", "RS256", rsaPrivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := LoadKey("ed", "EdDSA", ed25519KeyFile(t, dir), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := NewKeySet("rsa", rsaKey, edKey, NewHMACKey("hmac", []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := set.JWKS().Keys
+	if len(keys) != 2 || keys[0].Kid != "ed" || keys[1].Kid != "rsa" {
+		t.Fatalf("JWKS = %+v, want the ed and rsa keys", keys)
+	}
+	if keys[0].Kty != "OKP" || keys[0].Crv != "Ed25519" || keys[0].Alg != "EdDSA" || keys[0].X == "" {
+		t.Errorf("ed25519 JWK = %+v", keys[0])
+	}
+	if keys[1].Kty != "RSA" || keys[1].Alg != "RS256" || keys[1].N == "" || keys[1].E != "AQAB" {
+		t.Errorf("rsa JWK = %+v", keys[1])
+	}
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+)
+
+// APIKeyRepo Struct
+type APIKeyRepo struct {
+	DB *sql.DB
+}
+
+// APIKeyOwner is the user an api key authenticates as
+type APIKeyOwner struct {
+	KeyID  string
+	UserID string
+	Email  string
+	Role   string
+	Scopes []string
+	// OrgID is the organization the key acts in and OrgRole the role of the user
+	// in it, both empty for keys created outside of any
+	OrgID   string
+	OrgRole string
+}
+
+// CreateAPIKey stores the hash of a new api key of user acting in the tenant of ctx
+func (r *APIKeyRepo) CreateAPIKey(ctx context.Context, userID string, name string, prefix string, keyHash string, scopes []string, expires *time.Time) (*dto.APIKeyResponse, *dto.ErrorResponse) {
+	sqlQuery := "INSERT INTO api_keys(user_id,name,prefix,key_hash,scopes,expires,created,org_id) VALUES($1,$2,$3,$4,$5,$6,$7,$8) returning id;"
+	created := time.Now()
+	tenant := tenantID(ctx)
+	var id string
+	err := r.DB.QueryRowContext(ctx, sqlQuery, userID, name, prefix, keyHash, strings.Join(scopes, " "), expires, created, tenant).Scan(&id)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create api key"}
+	}
+	if err = recordAudit(ctx, r.DB, "create", "api_keys", id); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create api key"}
+	}
+	return &dto.APIKeyResponse{ID: id, Name: name, Prefix: prefix, Scopes: scopes, OrgID: tenant.String, Expires: expires, Created: created}, nil
+}
+
+// GetAPIKeys lists the api keys of user, revoked ones included
+func (r *APIKeyRepo) GetAPIKeys(ctx context.Context, userID string) ([]dto.APIKeyResponse, *dto.ErrorResponse) {
+	sqlQuery := "SELECT id,name,prefix,scopes,COALESCE(org_id::text,''),expires,last_used,revoked,created FROM api_keys WHERE user_id=$1 ORDER BY created;"
+	rows, err := r.DB.QueryContext(ctx, sqlQuery, userID)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch api keys"}
+	}
+	defer rows.Close()
+
+	keys := []dto.APIKeyResponse{}
+	for rows.Next() {
+		var key dto.APIKeyResponse
+		var scopes string
+		var expires, lastUsed, revoked, created sql.NullTime
+		if err = rows.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &key.OrgID, &expires, &lastUsed, &revoked, &created); err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch api keys"}
+		}
+		key.Scopes = strings.Fields(scopes)
+		key.Expires = nullTimePtr(expires)
+		key.LastUsed = nullTimePtr(lastUsed)
+		key.Revoked = nullTimePtr(revoked)
+		key.Created = created.Time
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch api keys"}
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes the api key id of user
+func (r *APIKeyRepo) RevokeAPIKey(ctx context.Context, userID string, id string) *dto.ErrorResponse {
+	result, err := r.DB.ExecContext(ctx, "UPDATE api_keys SET revoked=$1 WHERE id=$2 AND user_id=$3 AND revoked IS NULL;", time.Now(), id, userID)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke api key"}
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "api key not found"}
+	}
+	if err = recordAudit(ctx, r.DB, "revoke", "api_keys", id); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke api key"}
+	}
+	return nil
+}
+
+// RevokeUserAPIKeys revokes every api key of user
+func (r *APIKeyRepo) RevokeUserAPIKeys(userID string) *dto.ErrorResponse {
+	sqlQuery := "UPDATE api_keys SET revoked=$1 WHERE user_id=$2 AND revoked IS NULL;"
+	if _, err := r.DB.ExecContext(context.Background(), sqlQuery, time.Now(), userID); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke api keys"}
+	}
+	return nil
+}
+
+// UseAPIKey returns the owner of the valid api key with keyHash and records it was used,
+// keys of an organization the owner is no longer a member of are not valid
+func (r *APIKeyRepo) UseAPIKey(ctx context.Context, keyHash string) (*APIKeyOwner, *dto.ErrorResponse) {
+	member := "SELECT m.role FROM organization_members m WHERE m.org_id=k.org_id AND m.user_id=k.user_id"
+	sqlQuery := "UPDATE api_keys k SET last_used=$1 FROM users u WHERE u.id=k.user_id AND u.deleted_at IS NULL AND k.key_hash=$2 AND k.revoked IS NULL AND (k.expires IS NULL OR k.expires>$1) " +
+		"AND (k.org_id IS NULL OR EXISTS(" + member + ")) " +
+		"returning k.id,k.user_id,k.scopes,u.email,u.role,COALESCE(k.org_id::text,''),COALESCE((" + member + "),'');"
+	owner := APIKeyOwner{}
+	var scopes string
+	err := r.DB.QueryRowContext(ctx, sqlQuery, time.Now(), keyHash).Scan(&owner.KeyID, &owner.UserID, &scopes, &owner.Email, &owner.Role, &owner.OrgID, &owner.OrgRole)
+	if err == sql.ErrNoRows {
+		return nil, &dto.ErrorResponse{Status: http.StatusForbidden, Error: dto.NotFoundError, Message: "Invalid api key"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to verify api key"}
+	}
+	owner.Scopes = strings.Fields(scopes)
+	return &owner, nil
+}
+
+// nullTimePtr converts a nullable column to a pointer omitted from json when null
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/auth"
+)
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// actorID returns the user id of the real caller of ctx, null for anonymous requests
+func actorID(ctx context.Context) sql.NullString {
+	userID := auth.ActorIDFromContext(ctx)
+	return sql.NullString{String: userID, Valid: userID != ""}
+}
+
+// recordAudit records that the principal of ctx performed action on an entity
+func recordAudit(ctx context.Context, db execer, action string, entity string, entityID string) error {
+	sqlQuery := "INSERT INTO audit_log(actor_id,action,entity,entity_id,created) VALUES($1,$2,$3,$4,$5);"
+	_, err := db.ExecContext(ctx, sqlQuery, actorID(ctx), action, entity, entityID, time.Now())
+	return err
+}
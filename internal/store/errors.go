@@ -0,0 +1,25 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// uniqueViolation is the postgres error code of unique constraint violations
+const uniqueViolation = "23505"
+
+// isUniqueViolation reports whether err is a postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// foreignKeyViolation is the postgres error code of foreign key constraint violations
+const foreignKeyViolation = "23503"
+
+// isForeignKeyViolation reports whether err is a postgres foreign key constraint violation
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation
+}
@@ -23,11 +23,11 @@ type Document struct {
 }
 
 
-func (r *FilesRepo) InsertFiles(data []Document, parentID string) (*dto.FilesResponse, *dto.ErrorResponse) {
+func (r *FilesRepo) InsertFiles(ctx context.Context, data []Document, parentID string) (*dto.FilesResponse, *dto.ErrorResponse) {
 	var insertedID []uuid.UUID
 	for _, file := range data {
-		sql := "INSERT INTO document(parent_id,name,data,created) VALUES($1,$2,$3,$4) returning id;"
-		row := r.DB.QueryRowContext(context.Background(), sql, parentID, file.Name, file.Data, time.Now())
+		sql := "INSERT INTO document(parent_id,name,data,created,created_by,org_id) VALUES($1,$2,$3,$4,$5,$6) returning id;"
+		row := r.DB.QueryRowContext(ctx, sql, parentID, file.Name, file.Data, time.Now(), actorID(ctx), tenantID(ctx))
 		var lastInsertID uuid.UUID
 		if row.Scan(&lastInsertID) != nil {
 			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: nil, Message: "Some went wrong"}
@@ -39,16 +39,16 @@ func (r *FilesRepo) InsertFiles(data []Document, parentID string) (*dto.FilesRes
 	}, nil
 }
 
-// FindFile finds file with id from database
-func (r *FilesRepo) FindFile(ID string) (*dto.FileResponse, *dto.ErrorResponse) {
-	sqlQuery := "SELECT id,parent_id,name,data,created FROM document WHERE id=$1"
+// FindFile finds file with id of the tenant of ctx from database
+func (r *FilesRepo) FindFile(ctx context.Context, ID string) (*dto.FileResponse, *dto.ErrorResponse) {
+	sqlQuery := "SELECT id,parent_id,name,data,created FROM document WHERE id=$1 AND org_id IS NOT DISTINCT FROM $2"
 
 	var rows *sql.Rows
 	var err error
 	logger.Logger().Debug("Finding file",zap.String("ID",ID))
 
 	// select sql message
-	if rows, err = r.DB.QueryContext(context.Background(), sqlQuery, ID); err != nil {
+	if rows, err = r.DB.QueryContext(ctx, sqlQuery, ID, tenantID(ctx)); err != nil {
 		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: fmt.Sprintf("Failed find file %s", ID)}
 	}
 
@@ -70,16 +70,16 @@ func (r *FilesRepo) FindFile(ID string) (*dto.FileResponse, *dto.ErrorResponse)
 	}
 }
 
-// FindFiles finds all files with parent id from database
-func (r *FilesRepo) FindFiles(parentID string) (*[]dto.FileResponse, *dto.ErrorResponse) {
-	sqlQuery := "SELECT id,parent_id,name,data,created FROM document WHERE parent_id=$1"
+// FindFiles finds all files with parent id of the tenant of ctx from database
+func (r *FilesRepo) FindFiles(ctx context.Context, parentID string) (*[]dto.FileResponse, *dto.ErrorResponse) {
+	sqlQuery := "SELECT id,parent_id,name,data,created FROM document WHERE parent_id=$1 AND org_id IS NOT DISTINCT FROM $2"
 	var rows *sql.Rows
 	var err error
 
 	logger.Logger().Debug("Finding files",zap.String("parentID",parentID))
 
 	// select sql message
-	if rows, err = r.DB.Query(sqlQuery, parentID); err != nil {
+	if rows, err = r.DB.QueryContext(ctx, sqlQuery, parentID, tenantID(ctx)); err != nil {
 		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed find files"}
 	}
 
@@ -98,20 +98,49 @@ func (r *FilesRepo) FindFiles(parentID string) (*[]dto.FileResponse, *dto.ErrorR
 	return &response, nil
 }
 
-// DeleteFileWithID deletes all files with id
-func (r *FilesRepo) DeleteFileWithID(id string) *dto.ErrorResponse {
-	_, err := r.DB.Exec("DELETE FROM document WHERE id=$1;", id)
+// DeleteFileWithID deletes all files with id of the tenant of ctx
+func (r *FilesRepo) DeleteFileWithID(ctx context.Context, id string) *dto.ErrorResponse {
+	return r.deleteFiles(ctx, "DELETE FROM document WHERE id=$1 AND org_id IS NOT DISTINCT FROM $2 returning id;", id, "Failed delete file")
+}
+
+// DeleteFilesWithParent deletes all files with parent id of the tenant of ctx
+func (r *FilesRepo) DeleteFilesWithParent(ctx context.Context, parentID string) *dto.ErrorResponse {
+	return r.deleteFiles(ctx, "DELETE FROM document WHERE parent_id=$1 AND org_id IS NOT DISTINCT FROM $2 returning id;", parentID, "Failed delete files")
+}
+
+// deleteFiles runs a delete query taking arg and the tenant of ctx returning ids and audits every deleted file
+func (r *FilesRepo) deleteFiles(ctx context.Context, sqlQuery string, arg string, message string) *dto.ErrorResponse {
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed delete file"}
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: message}
 	}
-	return nil
-}
+	defer tx.Rollback()
 
-// DeleteFilesWithParent deletes all files with parent id
-func (r *FilesRepo) DeleteFilesWithParent(parentID string) *dto.ErrorResponse {
-	_, err := r.DB.Exec("DELETE FROM document WHERE parent_id=$1;", parentID)
+	rows, err := tx.QueryContext(ctx, sqlQuery, arg, tenantID(ctx))
 	if err != nil {
-		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed delete files"}
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: message}
+	}
+	var deletedID []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: message}
+		}
+		deletedID = append(deletedID, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: message}
+	}
+
+	for _, id := range deletedID {
+		if err = recordAudit(ctx, tx, "delete", "document", id); err != nil {
+			return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: message}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: message}
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+)
+
+// IdentityRepo Struct
+type IdentityRepo struct {
+	DB *sql.DB
+}
+
+// FindIdentityUser returns the id of the user linked to subject of the external issuer
+func (r *IdentityRepo) FindIdentityUser(ctx context.Context, issuer string, subject string) (string, *dto.ErrorResponse) {
+	var userID string
+	err := r.DB.QueryRowContext(ctx, "SELECT user_id FROM user_identities WHERE issuer=$1 AND subject=$2;", issuer, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "identity not found"}
+	}
+	if err != nil {
+		return "", &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to find identity"}
+	}
+	return userID, nil
+}
+
+// LinkIdentity links subject of the external issuer to user
+func (r *IdentityRepo) LinkIdentity(ctx context.Context, userID string, issuer string, subject string, email string) *dto.ErrorResponse {
+	sqlQuery := "INSERT INTO user_identities(user_id,issuer,subject,email,created) VALUES($1,$2,$3,$4,$5) returning id;"
+	var id string
+	if err := r.DB.QueryRowContext(ctx, sqlQuery, userID, issuer, subject, email, time.Now()).Scan(&id); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to link identity"}
+	}
+	if err := recordAudit(ctx, r.DB, "link", "user_identities", id); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to link identity"}
+	}
+	return nil
+}
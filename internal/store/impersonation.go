@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ImpersonationRepo records requests admins make acting as another user
+type ImpersonationRepo struct {
+	DB *sql.DB
+}
+
+// ImpersonatedRequest is a request made with an impersonation token
+type ImpersonatedRequest struct {
+	ActorID string
+	UserID  string
+	TokenID string
+	Method  string
+	Path    string
+	Status  int
+	IP      string
+}
+
+// RecordImpersonatedRequest appends request to the impersonation log
+func (r *ImpersonationRepo) RecordImpersonatedRequest(ctx context.Context, request ImpersonatedRequest) error {
+	sqlQuery := "INSERT INTO impersonation_log(actor_id,user_id,token_id,method,path,status,ip,created) VALUES($1,$2,$3,$4,$5,$6,$7,$8);"
+	_, err := r.DB.ExecContext(ctx, sqlQuery, request.ActorID, request.UserID, request.TokenID, request.Method, request.Path, request.Status, request.IP, time.Now())
+	return err
+}
+
+// RecordImpersonation records in the audit log that the principal of ctx started impersonating user
+func (r *ImpersonationRepo) RecordImpersonation(ctx context.Context, userID string) error {
+	return recordAudit(ctx, r.DB, "impersonate", "users", userID)
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/password"
+)
+
+// InvitationRepo stores invitations of users who choose their own name and password
+type InvitationRepo struct {
+	DB *sql.DB
+	// Hasher hashes the passwords of invitees
+	Hasher password.Hasher
+}
+
+// CreateInvitation stores an invitation and revokes older pending invitations of
+// the same address to the same organization
+func (r *InvitationRepo) CreateInvitation(ctx context.Context, request dto.InvitationRequest, tokenHash string, expires time.Time) (*dto.InvitationResponse, *dto.ErrorResponse) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create invitation"}
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	org := sql.NullString{String: request.OrgID, Valid: request.OrgID != ""}
+	orgRole := sql.NullString{String: request.OrgRole, Valid: request.OrgRole != ""}
+	sqlQuery := "UPDATE invitations SET revoked=$1 WHERE lower(email)=$2 AND org_id IS NOT DISTINCT FROM $3 AND accepted IS NULL AND revoked IS NULL;"
+	if _, err = tx.ExecContext(ctx, sqlQuery, now, request.Email, org); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create invitation"}
+	}
+	invitedBy := actorID(ctx)
+	response := dto.InvitationResponse{Email: request.Email, Role: request.Role, OrgID: request.OrgID, OrgRole: request.OrgRole,
+		InvitedBy: invitedBy.String, Expires: expires, Created: now}
+	sqlQuery = "INSERT INTO invitations(email,role,org_id,org_role,token_hash,invited_by,expires,created) VALUES($1,$2,$3,$4,$5,$6,$7,$8) returning id;"
+	err = tx.QueryRowContext(ctx, sqlQuery, request.Email, request.Role, org, orgRole, tokenHash, invitedBy, expires, now).Scan(&response.ID)
+	if isForeignKeyViolation(err) {
+		return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: err, Message: "organization not found"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create invitation"}
+	}
+	if err = recordAudit(ctx, tx, "create", "invitations", response.ID); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create invitation"}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create invitation"}
+	}
+	return &response, nil
+}
+
+// GetInvitations lists the pending invitations to the tenant of ctx, all of them outside of any
+func (r *InvitationRepo) GetInvitations(ctx context.Context) ([]dto.InvitationResponse, *dto.ErrorResponse) {
+	sqlQuery := "SELECT id,email,role,COALESCE(org_id::text,''),COALESCE(org_role,''),COALESCE(invited_by::text,''),expires,created FROM invitations " +
+		"WHERE accepted IS NULL AND revoked IS NULL AND expires>$1 AND ($2::uuid IS NULL OR org_id=$2) ORDER BY created DESC;"
+	rows, err := r.DB.QueryContext(ctx, sqlQuery, time.Now(), tenantID(ctx))
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch invitations"}
+	}
+	defer rows.Close()
+
+	invitations := []dto.InvitationResponse{}
+	for rows.Next() {
+		var invitation dto.InvitationResponse
+		err = rows.Scan(&invitation.ID, &invitation.Email, &invitation.Role, &invitation.OrgID, &invitation.OrgRole, &invitation.InvitedBy, &invitation.Expires, &invitation.Created)
+		if err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch invitations"}
+		}
+		invitations = append(invitations, invitation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch invitations"}
+	}
+	return invitations, nil
+}
+
+// RevokeInvitation revokes the pending invitation id to the tenant of ctx
+func (r *InvitationRepo) RevokeInvitation(ctx context.Context, id string) *dto.ErrorResponse {
+	sqlQuery := "UPDATE invitations SET revoked=$1 WHERE id=$2 AND accepted IS NULL AND revoked IS NULL AND ($3::uuid IS NULL OR org_id=$3);"
+	result, err := r.DB.ExecContext(ctx, sqlQuery, time.Now(), id, tenantID(ctx))
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke invitation"}
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "invitation not found"}
+	}
+	if err = recordAudit(ctx, r.DB, "revoke", "invitations", id); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke invitation"}
+	}
+	return nil
+}
+
+// PeekInvitation returns the valid invitation with tokenHash without using it up
+func (r *InvitationRepo) PeekInvitation(ctx context.Context, tokenHash string) (*dto.InvitationResponse, *dto.ErrorResponse) {
+	sqlQuery := "SELECT id,email,role,COALESCE(org_id::text,''),COALESCE(org_role,''),COALESCE(invited_by::text,''),expires,created FROM invitations " +
+		"WHERE token_hash=$1 AND accepted IS NULL AND revoked IS NULL AND expires>$2;"
+	var invitation dto.InvitationResponse
+	err := r.DB.QueryRowContext(ctx, sqlQuery, tokenHash, time.Now()).
+		Scan(&invitation.ID, &invitation.Email, &invitation.Role, &invitation.OrgID, &invitation.OrgRole, &invitation.InvitedBy, &invitation.Expires, &invitation.Created)
+	if err == sql.ErrNoRows {
+		return nil, &dto.ErrorResponse{Status: http.StatusBadRequest, Error: dto.NotFoundError, Message: "Invalid or expired invitation"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to verify invitation"}
+	}
+	return &invitation, nil
+}
+
+// AcceptInvitation uses up the valid invitation with tokenHash and creates the
+// invited user with the name and password of request. The address is verified
+// as the token was sent to it.
+func (r *InvitationRepo) AcceptInvitation(ctx context.Context, tokenHash string, request dto.AcceptInvitationRequest) (*dto.UserResponse, *dto.ErrorResponse) {
+	hash, err := r.Hasher.Hash(request.Password)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var id string
+	var org, orgRole, invitedBy sql.NullString
+	response := dto.UserResponse{FirstName: request.FirstName, LastName: request.LastName}
+	sqlQuery := "SELECT id,email,role,org_id,org_role,invited_by FROM invitations WHERE token_hash=$1 AND accepted IS NULL AND revoked IS NULL AND expires>$2 FOR UPDATE;"
+	err = tx.QueryRowContext(ctx, sqlQuery, tokenHash, now).Scan(&id, &response.Email, &response.Role, &org, &orgRole, &invitedBy)
+	if err == sql.ErrNoRows {
+		return nil, &dto.ErrorResponse{Status: http.StatusBadRequest, Error: dto.NotFoundError, Message: "Invalid or expired invitation"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+
+	sqlQuery = "INSERT INTO users(first_name,last_name,email,email_verified_at,password,role,created,created_by) VALUES($1,$2,$3,$4,$5,$6,$4,$7) returning id;"
+	err = tx.QueryRowContext(ctx, sqlQuery, request.FirstName, request.LastName, response.Email, now, hash, response.Role, invitedBy).Scan(&response.ID)
+	if err != nil {
+		return nil, insertUserError(err)
+	}
+	if org.Valid {
+		sqlQuery = "INSERT INTO organization_members(org_id,user_id,role,created) VALUES($1,$2,$3,$4);"
+		if _, err = tx.ExecContext(ctx, sqlQuery, org, response.ID, orgRole, now); err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+		}
+	}
+	if _, err = tx.ExecContext(ctx, "UPDATE invitations SET accepted=$1,user_id=$2 WHERE id=$3;", now, response.ID, id); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+	if err = recordAudit(ctx, tx, "accept", "invitations", id); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+	return &response, nil
+}
+
+// JoinInvitation uses up the valid invitation to an organization with tokenHash
+// sent to email and adds user to the organization. The role of the invitation
+// is only given to invitees who become users by accepting it.
+func (r *InvitationRepo) JoinInvitation(ctx context.Context, tokenHash string, userID string, email string) (*dto.OrganizationResponse, *dto.ErrorResponse) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var id string
+	var organization dto.OrganizationResponse
+	sqlQuery := "SELECT i.id,o.id,o.name,o.created,i.org_role FROM invitations i JOIN organizations o ON o.id=i.org_id " +
+		"WHERE i.token_hash=$1 AND lower(i.email)=$2 AND i.accepted IS NULL AND i.revoked IS NULL AND i.expires>$3 FOR UPDATE OF i;"
+	err = tx.QueryRowContext(ctx, sqlQuery, tokenHash, dto.NormalizeEmail(email), now).Scan(&id, &organization.ID, &organization.Name, &organization.Created, &organization.Role)
+	if err == sql.ErrNoRows {
+		return nil, &dto.ErrorResponse{Status: http.StatusBadRequest, Error: dto.NotFoundError, Message: "Invalid or expired invitation"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+
+	sqlQuery = "INSERT INTO organization_members(org_id,user_id,role,created) VALUES($1,$2,$3,$4);"
+	_, err = tx.ExecContext(ctx, sqlQuery, organization.ID, userID, organization.Role, now)
+	if isUniqueViolation(err) {
+		return nil, &dto.ErrorResponse{Status: http.StatusConflict, Error: err, Message: "User is a member already"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+	if _, err = tx.ExecContext(ctx, "UPDATE invitations SET accepted=$1,user_id=$2 WHERE id=$3;", now, userID, id); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+	if err = recordAudit(ctx, tx, "accept", "invitations", id); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to accept invitation"}
+	}
+	return &organization, nil
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+)
+
+// OrgRepo stores organizations and the membership of users in them
+type OrgRepo struct {
+	DB *sql.DB
+}
+
+// tenantID returns the organization the principal of ctx acts in, null outside of any
+func tenantID(ctx context.Context) sql.NullString {
+	orgID := auth.TenantIDFromContext(ctx)
+	return sql.NullString{String: orgID, Valid: orgID != ""}
+}
+
+// userInTenant returns the condition limiting users to members of the tenant
+// passed as parameter number param, a null tenant does not limit them
+func userInTenant(param int) string {
+	return fmt.Sprintf("($%[1]d::uuid IS NULL OR EXISTS(SELECT 1 FROM organization_members m WHERE m.user_id=users.id AND m.org_id=$%[1]d))", param)
+}
+
+// CreateOrganization creates an organization owned by user
+func (r *OrgRepo) CreateOrganization(ctx context.Context, name string, ownerID string) (*dto.OrganizationResponse, *dto.ErrorResponse) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create organization"}
+	}
+	defer tx.Rollback()
+
+	response := dto.OrganizationResponse{Name: name, Role: dto.OrgRoleOwner, Created: time.Now()}
+	sqlQuery := "INSERT INTO organizations(name,created,created_by) VALUES($1,$2,$3) returning id;"
+	if err = tx.QueryRowContext(ctx, sqlQuery, name, response.Created, actorID(ctx)).Scan(&response.ID); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create organization"}
+	}
+	sqlQuery = "INSERT INTO organization_members(org_id,user_id,role,created) VALUES($1,$2,$3,$4);"
+	if _, err = tx.ExecContext(ctx, sqlQuery, response.ID, ownerID, dto.OrgRoleOwner, response.Created); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create organization"}
+	}
+	if err = recordAudit(ctx, tx, "create", "organizations", response.ID); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create organization"}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create organization"}
+	}
+	return &response, nil
+}
+
+// GetOrganizations lists the organizations user is a member of in the order they were joined
+func (r *OrgRepo) GetOrganizations(ctx context.Context, userID string) ([]dto.OrganizationResponse, *dto.ErrorResponse) {
+	sqlQuery := "SELECT o.id,o.name,m.role,o.created FROM organization_members m JOIN organizations o ON o.id=m.org_id " +
+		"WHERE m.user_id=$1 ORDER BY m.created,o.id;"
+	rows, err := r.DB.QueryContext(ctx, sqlQuery, userID)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch organizations"}
+	}
+	defer rows.Close()
+
+	organizations := []dto.OrganizationResponse{}
+	for rows.Next() {
+		var organization dto.OrganizationResponse
+		if err = rows.Scan(&organization.ID, &organization.Name, &organization.Role, &organization.Created); err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch organizations"}
+		}
+		organizations = append(organizations, organization)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch organizations"}
+	}
+	return organizations, nil
+}
+
+// GetMembership returns the role of user in organization orgID
+func (r *OrgRepo) GetMembership(ctx context.Context, orgID string, userID string) (*dto.Membership, *dto.ErrorResponse) {
+	membership := dto.Membership{OrgID: orgID, UserID: userID}
+	err := r.DB.QueryRowContext(ctx, "SELECT role FROM organization_members WHERE org_id=$1 AND user_id=$2;", orgID, userID).Scan(&membership.Role)
+	if err == sql.ErrNoRows {
+		return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "organization not found"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch membership"}
+	}
+	return &membership, nil
+}
+
+// DefaultMembership returns the membership of the organization user joined first, nil
+// for users outside of any organization
+func (r *OrgRepo) DefaultMembership(ctx context.Context, userID string) (*dto.Membership, *dto.ErrorResponse) {
+	membership := dto.Membership{UserID: userID}
+	sqlQuery := "SELECT org_id,role FROM organization_members WHERE user_id=$1 ORDER BY created,org_id LIMIT 1;"
+	err := r.DB.QueryRowContext(ctx, sqlQuery, userID).Scan(&membership.OrgID, &membership.Role)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch membership"}
+	}
+	return &membership, nil
+}
+
+// GetMembers lists the members of organization orgID
+func (r *OrgRepo) GetMembers(ctx context.Context, orgID string) ([]dto.MemberResponse, *dto.ErrorResponse) {
+	sqlQuery := "SELECT u.id,u.first_name,u.last_name,u.email,m.role,m.created FROM organization_members m JOIN users u ON u.id=m.user_id " +
+		"WHERE m.org_id=$1 AND u.deleted_at IS NULL ORDER BY m.created,u.id;"
+	rows, err := r.DB.QueryContext(ctx, sqlQuery, orgID)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch members"}
+	}
+	defer rows.Close()
+
+	members := []dto.MemberResponse{}
+	for rows.Next() {
+		var member dto.MemberResponse
+		if err = rows.Scan(&member.UserID, &member.FirstName, &member.LastName, &member.Email, &member.Role, &member.Created); err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch members"}
+		}
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch members"}
+	}
+	return members, nil
+}
+
+// AddMember adds user to organization orgID with role
+func (r *OrgRepo) AddMember(ctx context.Context, orgID string, userID string, role string) *dto.ErrorResponse {
+	sqlQuery := "INSERT INTO organization_members(org_id,user_id,role,created) VALUES($1,$2,$3,$4);"
+	_, err := r.DB.ExecContext(ctx, sqlQuery, orgID, userID, role, time.Now())
+	if isUniqueViolation(err) {
+		return &dto.ErrorResponse{Status: http.StatusConflict, Error: err, Message: "User is a member already"}
+	}
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to add member"}
+	}
+	if err = recordAudit(ctx, r.DB, "add_member", "organizations", orgID+"/"+userID); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to add member"}
+	}
+	return nil
+}
+
+// SetMemberRole changes the role of member userID of organization orgID, the
+// last owner can not give up ownership
+func (r *OrgRepo) SetMemberRole(ctx context.Context, orgID string, userID string, role string) *dto.ErrorResponse {
+	return r.changeMember(ctx, orgID, userID, role == dto.OrgRoleOwner, "set_member_role",
+		"UPDATE organization_members SET role=$3 WHERE org_id=$1 AND user_id=$2;", role)
+}
+
+// RemoveMember removes member userID from organization orgID, the last owner can not leave
+func (r *OrgRepo) RemoveMember(ctx context.Context, orgID string, userID string) *dto.ErrorResponse {
+	return r.changeMember(ctx, orgID, userID, false, "remove_member",
+		"DELETE FROM organization_members WHERE org_id=$1 AND user_id=$2;")
+}
+
+// changeMember runs sqlQuery on a member with the organization locked, so that
+// concurrent changes can not leave it without an owner unless staysOwner is set
+func (r *OrgRepo) changeMember(ctx context.Context, orgID string, userID string, staysOwner bool, action string, sqlQuery string, args ...interface{}) *dto.ErrorResponse {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to change member"}
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "SELECT 1 FROM organizations WHERE id=$1 FOR UPDATE;", orgID); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to change member"}
+	}
+	var role string
+	var owners int
+	err = tx.QueryRowContext(ctx, "SELECT role,(SELECT count(*) FROM organization_members WHERE org_id=$1 AND role=$3) FROM organization_members WHERE org_id=$1 AND user_id=$2;",
+		orgID, userID, dto.OrgRoleOwner).Scan(&role, &owners)
+	if err == sql.ErrNoRows {
+		return &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "member not found"}
+	}
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to change member"}
+	}
+	if role == dto.OrgRoleOwner && !staysOwner && owners == 1 {
+		return &dto.ErrorResponse{Status: http.StatusConflict, Error: nil, Message: "Organization needs an owner"}
+	}
+
+	if _, err = tx.ExecContext(ctx, sqlQuery, append([]interface{}{orgID, userID}, args...)...); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to change member"}
+	}
+	if err = recordAudit(ctx, tx, action, "organizations", orgID+"/"+userID); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to change member"}
+	}
+	if err = tx.Commit(); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to change member"}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+)
+
+// PrivacyRepo answers data subject requests, exporting and erasing the data of users
+type PrivacyRepo struct {
+	DB *sql.DB
+}
+
+// CreatePrivacyRequest records a data subject request of kind for user made by the principal of ctx
+func (r *PrivacyRepo) CreatePrivacyRequest(ctx context.Context, userID string, kind string, status string) (*dto.PrivacyRequestResponse, *dto.ErrorResponse) {
+	sqlQuery := "INSERT INTO privacy_requests(user_id,kind,status,requested_by,created) VALUES($1,$2,$3,$4,$5) returning id;"
+	request := dto.PrivacyRequestResponse{UserID: userID, Kind: kind, Status: status, Created: time.Now()}
+	requestedBy := actorID(ctx)
+	if err := r.DB.QueryRowContext(ctx, sqlQuery, userID, kind, status, requestedBy, request.Created).Scan(&request.ID); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to record request"}
+	}
+	request.RequestedBy = requestedBy.String
+	return &request, nil
+}
+
+// CompletePrivacyRequest records the outcome of a data subject request, failed when cause is set
+func (r *PrivacyRepo) CompletePrivacyRequest(ctx context.Context, id string, cause error) error {
+	status, message := dto.PrivacyRequestCompleted, sql.NullString{}
+	if cause != nil {
+		status, message = dto.PrivacyRequestFailed, sql.NullString{String: cause.Error(), Valid: true}
+	}
+	_, err := r.DB.ExecContext(ctx, "UPDATE privacy_requests SET status=$1,error=$2,completed=$3 WHERE id=$4;", status, message, time.Now(), id)
+	return err
+}
+
+// GetPrivacyRequests lists the data subject requests of user, newest first
+func (r *PrivacyRepo) GetPrivacyRequests(ctx context.Context, userID string) ([]dto.PrivacyRequestResponse, *dto.ErrorResponse) {
+	sqlQuery := "SELECT id,user_id,kind,status,requested_by,error,created,completed FROM privacy_requests WHERE user_id=$1 ORDER BY created DESC"
+	rows, err := r.DB.QueryContext(ctx, sqlQuery, userID)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch requests"}
+	}
+	defer rows.Close()
+
+	requests := []dto.PrivacyRequestResponse{}
+	for rows.Next() {
+		var request dto.PrivacyRequestResponse
+		var requestedBy, message sql.NullString
+		var completed sql.NullTime
+		if err = rows.Scan(&request.ID, &request.UserID, &request.Kind, &request.Status, &requestedBy, &message, &request.Created, &completed); err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch requests"}
+		}
+		request.RequestedBy, request.Error, request.Completed = requestedBy.String, message.String, nullTimePtr(completed)
+		requests = append(requests, request)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch requests"}
+	}
+	return requests, nil
+}
+
+// ClaimErasureRequest marks the oldest pending erasure request running and returns
+// it, ok is false when none is pending. Instances never claim the same request.
+func (r *PrivacyRepo) ClaimErasureRequest(ctx context.Context) (id string, userID string, ok bool, err error) {
+	sqlQuery := `UPDATE privacy_requests SET status=$1 WHERE id=(
+		SELECT id FROM privacy_requests WHERE kind=$2 AND status=$3 ORDER BY created LIMIT 1 FOR UPDATE SKIP LOCKED
+	) returning id,user_id;`
+	err = r.DB.QueryRowContext(ctx, sqlQuery, dto.PrivacyRequestRunning, dto.PrivacyRequestErasure, dto.PrivacyRequestPending).Scan(&id, &userID)
+	if err == sql.ErrNoRows {
+		return "", "", false, nil
+	}
+	return id, userID, err == nil, err
+}
+
+// ExportUser fetches the users row of user for a data export, deleted users which were not purged included
+func (r *PrivacyRepo) ExportUser(ctx context.Context, userID string) (*dto.UserExport, *dto.ErrorResponse) {
+	sqlQuery := "SELECT id,first_name,last_name,email,email_verified_at,totp_confirmed,role,failed_logins,last_failed_login,created,created_by,updated,deleted_at FROM users WHERE id=$1 AND " + userInTenant(2)
+	user := dto.UserExport{}
+	var verified, lastFailedLogin, created, updated, deletedAt sql.NullTime
+	var createdBy sql.NullString
+	err := r.DB.QueryRowContext(ctx, sqlQuery, userID, tenantID(ctx)).Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &verified, &user.IsUsing2FA,
+		&user.Role, &user.FailedLogins, &lastFailedLogin, &created, &createdBy, &updated, &deletedAt)
+	if err == sql.ErrNoRows {
+		return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "user not found"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to export user"}
+	}
+	user.EmailVerifiedAt, user.LastFailedLogin = nullTimePtr(verified), nullTimePtr(lastFailedLogin)
+	user.Created, user.Updated, user.DeletedAt = nullTimePtr(created), nullTimePtr(updated), nullTimePtr(deletedAt)
+	user.CreatedBy = createdBy.String
+	return &user, nil
+}
+
+// ExportDocuments calls fn with every document user created, one at a time so
+// the data of all documents is never held in memory together
+func (r *PrivacyRepo) ExportDocuments(ctx context.Context, userID string, fn func(document dto.DocumentExport, data []byte) error) error {
+	sqlQuery := "SELECT id,parent_id,name,created,data FROM document WHERE created_by=$1 AND EXISTS(SELECT 1 FROM users WHERE id=$1 AND " + userInTenant(2) + ") ORDER BY created,id"
+	rows, err := r.DB.QueryContext(ctx, sqlQuery, userID, tenantID(ctx))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var document dto.DocumentExport
+		var name sql.NullString
+		var created sql.NullTime
+		var data []byte
+		if err = rows.Scan(&document.ID, &document.ParentID, &name, &created, &data); err != nil {
+			return err
+		}
+		document.Name, document.Created = name.String, nullTimePtr(created)
+		if err = fn(document, data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// EraseUser deletes the documents, credentials and memberships of user and
+// anonymizes its users row, which stays deleted until purged so references keep
+// resolving, its invitations and the addresses it was seen from
+func (r *PrivacyRepo) EraseUser(ctx context.Context, userID string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked string
+	err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id=$1 AND "+userInTenant(2)+" FOR UPDATE;", userID, tenantID(ctx)).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return dto.NotFoundError
+	}
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		"DELETE FROM document WHERE created_by=$1;",
+		"DELETE FROM refresh_tokens WHERE user_id=$1;",
+		"DELETE FROM recovery_codes WHERE user_id=$1;",
+		"DELETE FROM user_tokens WHERE user_id=$1;",
+		"DELETE FROM api_keys WHERE user_id=$1;",
+		"DELETE FROM user_identities WHERE user_id=$1;",
+		"DELETE FROM sessions WHERE user_id=$1;",
+		"DELETE FROM organization_members WHERE user_id=$1;",
+		"UPDATE impersonation_log SET ip=NULL WHERE user_id=$1 OR actor_id=$1;",
+		"UPDATE invitations SET email='erased-' || id || '@invalid',revoked=COALESCE(revoked,now()) WHERE user_id=$1 OR lower(email)=(SELECT lower(email) FROM users WHERE id=$1);",
+		`UPDATE users SET first_name='',last_name='',email='erased-' || id || '@invalid',email_verified_at=NULL,password='',
+			is_2fa=false,totp_secret=NULL,totp_confirmed=false,totp_last_step=NULL,failed_logins=0,last_failed_login=NULL,token=NULL,
+			updated=now(),deleted_at=COALESCE(deleted_at,now()) WHERE id=$1;`,
+	}
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, statement, userID); err != nil {
+			return err
+		}
+	}
+	if err = recordAudit(ctx, tx, "erase", "users", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// RefreshTokenRepo Struct
+type RefreshTokenRepo struct {
+	DB *sql.DB
+}
+
+// RefreshToken db refresh token struct
+type RefreshToken struct {
+	ID       uuid.UUID
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+	Expires  time.Time
+}
+
+// CreateRefreshToken stores the hash of a new refresh token in the given family
+func (r *RefreshTokenRepo) CreateRefreshToken(userID uuid.UUID, familyID uuid.UUID, tokenHash string, expires time.Time) (*RefreshToken, *dto.ErrorResponse) {
+	sqlQuery := "INSERT INTO refresh_tokens(user_id,family_id,token_hash,expires,created) VALUES($1,$2,$3,$4,$5) returning id;"
+	row := r.DB.QueryRowContext(context.Background(), sqlQuery, userID, familyID, tokenHash, expires, time.Now())
+	var lastInsertID uuid.UUID
+	if err := row.Scan(&lastInsertID); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to insert refresh token"}
+	}
+	return &RefreshToken{ID: lastInsertID, UserID: userID, FamilyID: familyID, Expires: expires}, nil
+}
+
+// RotateRefreshToken consumes the refresh token with tokenHash and replaces it with newHash.
+// Presenting a token that was already rotated revokes every token of its family.
+func (r *RefreshTokenRepo) RotateRefreshToken(tokenHash string, newHash string, expires time.Time) (*RefreshToken, *dto.ErrorResponse) {
+	tx, err := r.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to refresh token"}
+	}
+	defer tx.Rollback()
+
+	sqlQuery := "SELECT id,user_id,family_id,expires,revoked,replaced_by FROM refresh_tokens WHERE token_hash=$1 FOR UPDATE"
+	current := RefreshToken{}
+	var revoked sql.NullTime
+	var replacedBy uuid.NullUUID
+	err = tx.QueryRow(sqlQuery, tokenHash).Scan(&current.ID, &current.UserID, &current.FamilyID, &current.Expires, &revoked, &replacedBy)
+	if err == sql.ErrNoRows {
+		return nil, &dto.ErrorResponse{Status: http.StatusForbidden, Error: dto.NotFoundError, Message: "Invalid refresh token"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to refresh token"}
+	}
+
+	if revoked.Valid && !replacedBy.Valid {
+		return nil, &dto.ErrorResponse{Status: http.StatusForbidden, Error: fmt.Errorf("refresh token revoked"), Message: "Refresh token revoked"}
+	}
+	if revoked.Valid {
+		// an already rotated token was replayed, so the whole family is compromised
+		logger.Logger().Warn("Refresh token reuse detected", zap.String("family", current.FamilyID.String()))
+		if _, err = tx.Exec("UPDATE refresh_tokens SET revoked=$1 WHERE family_id=$2 AND revoked IS NULL;", time.Now(), current.FamilyID); err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke refresh tokens"}
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke refresh tokens"}
+		}
+		return nil, &dto.ErrorResponse{Status: http.StatusForbidden, Error: fmt.Errorf("refresh token reused"), Message: "Refresh token already used"}
+	}
+	if time.Now().After(current.Expires) {
+		return nil, &dto.ErrorResponse{Status: http.StatusForbidden, Error: fmt.Errorf("refresh token expired"), Message: "Refresh token expired"}
+	}
+
+	next := RefreshToken{UserID: current.UserID, FamilyID: current.FamilyID, Expires: expires}
+	sqlQuery = "INSERT INTO refresh_tokens(user_id,family_id,token_hash,expires,created) VALUES($1,$2,$3,$4,$5) returning id;"
+	if err = tx.QueryRow(sqlQuery, next.UserID, next.FamilyID, newHash, next.Expires, time.Now()).Scan(&next.ID); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to insert refresh token"}
+	}
+	if _, err = tx.Exec("UPDATE refresh_tokens SET revoked=$1,replaced_by=$2 WHERE id=$3;", time.Now(), next.ID, current.ID); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to rotate refresh token"}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to rotate refresh token"}
+	}
+	return &next, nil
+}
+
+// RevokeRefreshToken revokes the family of the refresh token with tokenHash
+func (r *RefreshTokenRepo) RevokeRefreshToken(tokenHash string) *dto.ErrorResponse {
+	sqlQuery := "UPDATE refresh_tokens SET revoked=$1 WHERE revoked IS NULL AND family_id=(SELECT family_id FROM refresh_tokens WHERE token_hash=$2);"
+	if _, err := r.DB.ExecContext(context.Background(), sqlQuery, time.Now(), tokenHash); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke refresh token"}
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of user
+func (r *RefreshTokenRepo) RevokeUserRefreshTokens(userID string) *dto.ErrorResponse {
+	sqlQuery := "UPDATE refresh_tokens SET revoked=$1 WHERE user_id=$2 AND revoked IS NULL;"
+	if _, err := r.DB.ExecContext(context.Background(), sqlQuery, time.Now(), userID); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke refresh tokens"}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// RevocationRepo keeps revoked access tokens and sessions in postgres and caches them in memory
+type RevocationRepo struct {
+	DB *sql.DB
+
+	mu        sync.RWMutex
+	ttl       time.Duration
+	loaded    time.Time
+	tokens    map[string]time.Time
+	revokedAt map[string]time.Time
+	sessions  map[string]time.Time
+}
+
+// NewRevocationRepo creates a revocation repo whose cache is reloaded from database every ttl
+func NewRevocationRepo(db *sql.DB, ttl time.Duration) *RevocationRepo {
+	return &RevocationRepo{
+		DB:        db,
+		ttl:       ttl,
+		tokens:    map[string]time.Time{},
+		revokedAt: map[string]time.Time{},
+		sessions:  map[string]time.Time{},
+	}
+}
+
+// RevokeToken revokes a single access token until it expires
+func (r *RevocationRepo) RevokeToken(jti string, userID string, expires time.Time) *dto.ErrorResponse {
+	sqlQuery := "INSERT INTO revoked_tokens(jti,user_id,expires,created) VALUES($1,$2,$3,$4) ON CONFLICT (jti) DO NOTHING;"
+	if _, err := r.DB.ExecContext(context.Background(), sqlQuery, jti, userID, expires, time.Now()); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke token"}
+	}
+
+	r.mu.Lock()
+	r.tokens[jti] = expires
+	r.mu.Unlock()
+	return nil
+}
+
+// ConsumeToken revokes the single use token jti until it expires and reports
+// whether it was not used before
+func (r *RevocationRepo) ConsumeToken(jti string, userID string, expires time.Time) (bool, *dto.ErrorResponse) {
+	sqlQuery := "INSERT INTO revoked_tokens(jti,user_id,expires,created) VALUES($1,$2,$3,$4) ON CONFLICT (jti) DO NOTHING;"
+	result, err := r.DB.ExecContext(context.Background(), sqlQuery, jti, userID, expires, time.Now())
+	if err != nil {
+		return false, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke token"}
+	}
+
+	r.mu.Lock()
+	r.tokens[jti] = expires
+	r.mu.Unlock()
+	affected, _ := result.RowsAffected()
+	return affected == 1, nil
+}
+
+// RevokeUserTokens revokes every access token of user issued before the current
+// second, the iat claim has no finer precision so tokens issued right after the
+// revocation stay valid
+func (r *RevocationRepo) RevokeUserTokens(userID string) *dto.ErrorResponse {
+	before := time.Now().Truncate(time.Second)
+	sqlQuery := "INSERT INTO user_token_revocations(user_id,revoked_before) VALUES($1,$2) ON CONFLICT (user_id) DO UPDATE SET revoked_before=EXCLUDED.revoked_before;"
+	if _, err := r.DB.ExecContext(context.Background(), sqlQuery, userID, before); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke tokens"}
+	}
+
+	r.mu.Lock()
+	r.revokedAt[userID] = before
+	r.mu.Unlock()
+	return nil
+}
+
+// RevokeSession revokes a session of user with its refresh tokens and the access tokens issued for it
+func (r *RevocationRepo) RevokeSession(ctx context.Context, userID string, sessionID string) *dto.ErrorResponse {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke session"}
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var expires time.Time
+	sqlQuery := "UPDATE sessions SET revoked=$1 WHERE id=$2 AND user_id=$3 AND revoked IS NULL returning expires;"
+	err = tx.QueryRowContext(ctx, sqlQuery, now, sessionID, userID).Scan(&expires)
+	if err == sql.ErrNoRows {
+		return &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "session not found"}
+	}
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke session"}
+	}
+	if _, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked=$1 WHERE family_id=$2 AND revoked IS NULL;", now, sessionID); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke session"}
+	}
+	if err = tx.Commit(); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to revoke session"}
+	}
+
+	r.mu.Lock()
+	r.sessions[sessionID] = expires
+	r.mu.Unlock()
+	return nil
+}
+
+// IsRevoked reports whether the token with jti of session issued to user at issuedAt is revoked
+func (r *RevocationRepo) IsRevoked(jti string, sessionID string, userID string, issuedAt time.Time) bool {
+	r.reloadIfStale()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.tokens[jti]; ok {
+		return true
+	}
+	if _, ok := r.sessions[sessionID]; ok && sessionID != "" {
+		return true
+	}
+	if before, ok := r.revokedAt[userID]; ok && issuedAt.Before(before) {
+		return true
+	}
+	return false
+}
+
+// reloadIfStale reloads the cache from database so revocations of other instances are seen
+func (r *RevocationRepo) reloadIfStale() {
+	r.mu.RLock()
+	fresh := time.Since(r.loaded) < r.ttl
+	r.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	tokens, revokedAt, sessions, err := r.loadRevocations()
+	if err != nil {
+		// keep serving from the current cache, it is retried on the next request
+		logger.Logger().Error("Failed to load token revocations", zap.Error(err))
+		return
+	}
+
+	r.mu.Lock()
+	r.tokens = tokens
+	r.revokedAt = revokedAt
+	r.sessions = sessions
+	r.loaded = time.Now()
+	r.mu.Unlock()
+}
+
+// loadRevocations reads unexpired revoked tokens, per user revocations and unexpired revoked sessions
+func (r *RevocationRepo) loadRevocations() (map[string]time.Time, map[string]time.Time, map[string]time.Time, error) {
+	tokens, err := r.loadExpiring("SELECT jti,expires FROM revoked_tokens WHERE expires>$1")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sessions, err := r.loadExpiring("SELECT id,expires FROM sessions WHERE revoked IS NOT NULL AND expires>$1")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	revokedAt := map[string]time.Time{}
+	userRows, err := r.DB.QueryContext(context.Background(), "SELECT user_id,revoked_before FROM user_token_revocations")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer userRows.Close()
+	for userRows.Next() {
+		var userID string
+		var before time.Time
+		if err = userRows.Scan(&userID, &before); err != nil {
+			return nil, nil, nil, err
+		}
+		revokedAt[userID] = before
+	}
+	return tokens, revokedAt, sessions, userRows.Err()
+}
+
+// loadExpiring reads ids and expiry times of revocations which did not expire yet
+func (r *RevocationRepo) loadExpiring(sqlQuery string) (map[string]time.Time, error) {
+	revoked := map[string]time.Time{}
+	rows, err := r.DB.QueryContext(context.Background(), sqlQuery, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var expires time.Time
+		if err = rows.Scan(&id, &expires); err != nil {
+			return nil, err
+		}
+		revoked[id] = expires
+	}
+	return revoked, rows.Err()
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// seenInterval limits how often requests of a session update its last seen time
+const seenInterval = time.Minute
+
+// SessionRepo records login sessions, a session shares its id with its refresh token family
+type SessionRepo struct {
+	DB *sql.DB
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	swept time.Time
+}
+
+// NewSessionRepo creates a session repo
+func NewSessionRepo(db *sql.DB) *SessionRepo {
+	return &SessionRepo{DB: db, seen: map[string]time.Time{}}
+}
+
+// CreateSession records a new session of user acting in organization orgID, empty outside of any
+func (r *SessionRepo) CreateSession(ctx context.Context, id string, userID string, orgID string, ip string, userAgent string, expires time.Time) *dto.ErrorResponse {
+	now := time.Now()
+	sqlQuery := "INSERT INTO sessions(id,user_id,org_id,ip,user_agent,created,last_seen,expires) VALUES($1,$2,$3,$4,$5,$6,$6,$7);"
+	org := sql.NullString{String: orgID, Valid: orgID != ""}
+	if _, err := r.DB.ExecContext(ctx, sqlQuery, id, userID, org, ip, userAgent, now, expires); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create session"}
+	}
+	return nil
+}
+
+// RefreshSession extends a session when its refresh token is rotated and returns
+// the organization it acts in, empty outside of any
+func (r *SessionRepo) RefreshSession(ctx context.Context, id string, ip string, userAgent string, expires time.Time) (string, *dto.ErrorResponse) {
+	sqlQuery := "UPDATE sessions SET ip=$1,user_agent=$2,last_seen=$3,expires=$4 WHERE id=$5 returning COALESCE(org_id::text,'');"
+	var orgID string
+	err := r.DB.QueryRowContext(ctx, sqlQuery, ip, userAgent, time.Now(), expires, id).Scan(&orgID)
+	if err != nil && err != sql.ErrNoRows {
+		return "", &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to refresh session"}
+	}
+	return orgID, nil
+}
+
+// SetSessionTenant changes the organization session id acts in, empty for none
+func (r *SessionRepo) SetSessionTenant(ctx context.Context, id string, orgID string) *dto.ErrorResponse {
+	org := sql.NullString{String: orgID, Valid: orgID != ""}
+	if _, err := r.DB.ExecContext(ctx, "UPDATE sessions SET org_id=$1 WHERE id=$2;", org, id); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to switch organization"}
+	}
+	return nil
+}
+
+// SessionSeen updates the last seen time and address of a session, at most once per seenInterval
+func (r *SessionRepo) SessionSeen(ctx context.Context, id string, ip string, userAgent string) {
+	now := time.Now()
+	r.mu.Lock()
+	if now.Sub(r.seen[id]) < seenInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.seen[id] = now
+	r.sweep(now)
+	r.mu.Unlock()
+
+	sqlQuery := "UPDATE sessions SET ip=$1,user_agent=$2,last_seen=$3 WHERE id=$4;"
+	if _, err := r.DB.ExecContext(ctx, sqlQuery, ip, userAgent, now, id); err != nil {
+		logger.Logger().Error("Failed to update session", zap.String("session", id), zap.Error(err))
+	}
+}
+
+// GetUserSessions lists the sessions of user which still have a usable refresh token
+func (r *SessionRepo) GetUserSessions(ctx context.Context, userID string) ([]dto.SessionResponse, *dto.ErrorResponse) {
+	sqlQuery := "SELECT s.id,COALESCE(s.ip,''),COALESCE(s.user_agent,''),s.created,s.last_seen,s.expires FROM sessions s " +
+		"WHERE s.user_id=$1 AND s.revoked IS NULL AND s.expires>$2 " +
+		"AND EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id=s.id AND t.revoked IS NULL AND t.expires>$2) " +
+		"ORDER BY s.last_seen DESC;"
+	rows, err := r.DB.QueryContext(ctx, sqlQuery, userID, time.Now())
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch sessions"}
+	}
+	defer rows.Close()
+
+	sessions := []dto.SessionResponse{}
+	for rows.Next() {
+		var session dto.SessionResponse
+		if err = rows.Scan(&session.ID, &session.IP, &session.UserAgent, &session.Created, &session.LastSeen, &session.Expires); err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch sessions"}
+		}
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch sessions"}
+	}
+	return sessions, nil
+}
+
+// sweep forgets last seen times older than seenInterval, r.mu must be held
+func (r *SessionRepo) sweep(now time.Time) {
+	if now.Sub(r.swept) < seenInterval {
+		return
+	}
+	for id, seen := range r.seen {
+		if now.Sub(seen) >= seenInterval {
+			delete(r.seen, id)
+		}
+	}
+	r.swept = now
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// TwoFactorRepo Struct
+type TwoFactorRepo struct {
+	DB *sql.DB
+}
+
+// TOTP db totp state of a user
+type TOTP struct {
+	Secret    string
+	Confirmed bool
+}
+
+// GetTOTP gets the totp state of user
+func (r *TwoFactorRepo) GetTOTP(userID string) (*TOTP, *dto.ErrorResponse) {
+	sqlQuery := "SELECT totp_secret,totp_confirmed FROM users WHERE id=$1"
+	var secret sql.NullString
+	response := TOTP{}
+	err := r.DB.QueryRowContext(context.Background(), sqlQuery, userID).Scan(&secret, &response.Confirmed)
+	if err == sql.ErrNoRows {
+		return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "user not found"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch user"}
+	}
+	response.Secret = secret.String
+	return &response, nil
+}
+
+// SetTOTPSecret stores an unconfirmed totp secret for user
+func (r *TwoFactorRepo) SetTOTPSecret(userID string, secret string) *dto.ErrorResponse {
+	sqlQuery := "UPDATE users SET totp_secret=$1,totp_last_step=NULL WHERE id=$2 AND totp_confirmed=false;"
+	result, err := r.DB.ExecContext(context.Background(), sqlQuery, secret, userID)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to enroll two factor authentication"}
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &dto.ErrorResponse{Status: http.StatusConflict, Error: fmt.Errorf("already enrolled"), Message: "Two factor authentication is already enabled"}
+	}
+	return nil
+}
+
+// ConfirmTOTP enables two factor authentication for user and replaces its recovery codes
+func (r *TwoFactorRepo) ConfirmTOTP(userID string, step int64, codeHashes []string) *dto.ErrorResponse {
+	tx, err := r.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to confirm two factor authentication"}
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("UPDATE users SET totp_confirmed=true,is_2fa=true,totp_last_step=$1 WHERE id=$2;", step, userID); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to confirm two factor authentication"}
+	}
+	if _, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id=$1;", userID); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to delete recovery codes"}
+	}
+	for _, codeHash := range codeHashes {
+		if _, err = tx.Exec("INSERT INTO recovery_codes(user_id,code_hash,created) VALUES($1,$2,$3);", userID, codeHash, time.Now()); err != nil {
+			return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to insert recovery codes"}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to confirm two factor authentication"}
+	}
+	return nil
+}
+
+// DisableTOTP removes the totp secret and recovery codes of user
+func (r *TwoFactorRepo) DisableTOTP(userID string) *dto.ErrorResponse {
+	tx, err := r.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to disable two factor authentication"}
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("UPDATE users SET totp_secret=NULL,totp_confirmed=false,is_2fa=false,totp_last_step=NULL WHERE id=$1;", userID); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to disable two factor authentication"}
+	}
+	if _, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id=$1;", userID); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to delete recovery codes"}
+	}
+	if err = tx.Commit(); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to disable two factor authentication"}
+	}
+	return nil
+}
+
+// UseTOTPStep records step as used so a code can not be replayed
+func (r *TwoFactorRepo) UseTOTPStep(userID string, step int64) (bool, *dto.ErrorResponse) {
+	sqlQuery := "UPDATE users SET totp_last_step=$1 WHERE id=$2 AND (totp_last_step IS NULL OR totp_last_step<$1);"
+	result, err := r.DB.ExecContext(context.Background(), sqlQuery, step, userID)
+	if err != nil {
+		return false, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to verify code"}
+	}
+	affected, _ := result.RowsAffected()
+	return affected == 1, nil
+}
+
+// UseRecoveryCode marks the unused recovery code with codeHash as used
+func (r *TwoFactorRepo) UseRecoveryCode(userID string, codeHash string) (bool, *dto.ErrorResponse) {
+	sqlQuery := "UPDATE recovery_codes SET used=$1 WHERE user_id=$2 AND code_hash=$3 AND used IS NULL;"
+	result, err := r.DB.ExecContext(context.Background(), sqlQuery, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to verify recovery code"}
+	}
+	affected, _ := result.RowsAffected()
+	if affected == 1 {
+		logger.Logger().Info("Recovery code used", zap.String("userID", userID))
+	}
+	return affected == 1, nil
+}
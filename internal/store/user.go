@@ -3,54 +3,141 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
 	"github.com/mehmetkule/go-restapi/internal/dto"
 	"github.com/mehmetkule/go-restapi/logger"
+	"github.com/mehmetkule/go-restapi/internal/password"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // UserRepo Struct
 type UserRepo struct {
 	DB *sql.DB
+	// Hasher hashes stored passwords
+	Hasher password.Hasher
 }
 
 // CreateUser func
-func (r *UserRepo) CreateUser(request dto.UserRequest) (*dto.UserResponse, *dto.ErrorResponse) {
+func (r *UserRepo) CreateUser(ctx context.Context, request dto.UserRequest) (*dto.UserResponse, *dto.ErrorResponse) {
 	var lastInsertID uuid.UUID
 	var err error
-	if lastInsertID, err = r.insertCreateUser(request); err != nil {
-		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to insert user"}
+	request.Email = dto.NormalizeEmail(request.Email)
+	if lastInsertID, err = r.insertCreateUser(ctx, r.DB, request); err != nil {
+		return nil, insertUserError(err)
 	}
 	return &dto.UserResponse{
 		ID:        lastInsertID.String(),
 		FirstName: request.FirstName,
 		LastName:  request.LastName,
 		Email:     request.Email,
+		Role:      dto.RoleUser,
 	}, nil
 }
 
-// insertCreateUser func
-func (r *UserRepo) insertCreateUser(request dto.UserRequest) (uuid.UUID, error) {
-	sql := "INSERT INTO users(first_name,last_name,email,password,is_2fa,created) VALUES($1,$2,$3,$4,$5,$6) returning id;"
-	password := hashAndSalt([]byte(request.Password))
-	row := r.DB.QueryRowContext(context.Background(), sql, request.FirstName, request.LastName, request.Email, password, request.IsUsing2FA, time.Now())
+// insertCreateUser inserts the user of request, users created within a tenant become members of it
+func (r *UserRepo) insertCreateUser(ctx context.Context, db queryRower, request dto.UserRequest) (uuid.UUID, error) {
+	sql := "WITH created AS (INSERT INTO users(first_name,last_name,email,password,created,created_by) VALUES($1,$2,$3,$4,$5,$6) returning id), " +
+		"member AS (INSERT INTO organization_members(org_id,user_id,role,created) SELECT $7,id,'member',$5 FROM created WHERE $7::uuid IS NOT NULL) " +
+		"SELECT id FROM created;"
 	var lastInsertID uuid.UUID
+	hash, err := r.Hasher.Hash(request.Password)
+	if err != nil {
+		return lastInsertID, err
+	}
+	row := db.QueryRowContext(ctx, sql, request.FirstName, request.LastName, request.Email, hash, time.Now(), actorID(ctx), tenantID(ctx))
 	return lastInsertID, row.Scan(&lastInsertID)
 }
 
+// insertUserError maps errors inserting users, an address taken by another user is a conflict
+func insertUserError(err error) *dto.ErrorResponse {
+	if isUniqueViolation(err) {
+		return &dto.ErrorResponse{Status: http.StatusConflict, Error: err, Message: "Conflict Email"}
+	}
+	return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to insert user"}
+}
+
+// CreateUsers creates users in one transaction, either all of them or none. The
+// index of the request which failed is returned with the error.
+func (r *UserRepo) CreateUsers(ctx context.Context, requests []dto.UserRequest) ([]string, int, *dto.ErrorResponse) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, -1, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to insert user"}
+	}
+	defer tx.Rollback()
+
+	ids := make([]string, len(requests))
+	for i, request := range requests {
+		request.Email = dto.NormalizeEmail(request.Email)
+		id, err := r.insertCreateUser(ctx, tx, request)
+		if err != nil {
+			return nil, i, insertUserError(err)
+		}
+		ids[i] = id.String()
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, -1, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to insert user"}
+	}
+	return ids, -1, nil
+}
+
+// ExistingEmails returns which of emails belong to users already
+func (r *UserRepo) ExistingEmails(ctx context.Context, emails []string) (map[string]bool, *dto.ErrorResponse) {
+	normalized := make([]string, len(emails))
+	for i, email := range emails {
+		normalized[i] = dto.NormalizeEmail(email)
+	}
+	rows, err := r.DB.QueryContext(ctx, "SELECT lower(email) FROM users WHERE lower(email)=ANY($1) AND deleted_at IS NULL", pq.Array(normalized))
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed find user"}
+	}
+	defer rows.Close()
+	existing := map[string]bool{}
+	for rows.Next() {
+		var email string
+		if err = rows.Scan(&email); err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed find user"}
+		}
+		existing[email] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed find user"}
+	}
+	return existing, nil
+}
+
+// CreateExternalUser creates a user signing in through an external identity provider
+// which verified the email address, the user has no password until one is reset
+func (r *UserRepo) CreateExternalUser(ctx context.Context, firstName string, lastName string, email string) (*dto.UserResponse, *dto.ErrorResponse) {
+	sqlQuery := "INSERT INTO users(first_name,last_name,email,email_verified_at,password,created,created_by) VALUES($1,$2,$3,$4,'',$4,$5) returning id;"
+	var lastInsertID uuid.UUID
+	email = dto.NormalizeEmail(email)
+	if err := r.DB.QueryRowContext(ctx, sqlQuery, firstName, lastName, email, time.Now(), actorID(ctx)).Scan(&lastInsertID); err != nil {
+		return nil, insertUserError(err)
+	}
+	return &dto.UserResponse{
+		ID:        lastInsertID.String(),
+		FirstName: firstName,
+		LastName:  lastName,
+		Email:     email,
+		Role:      dto.RoleUser,
+	}, nil
+}
+
 // FindUserByID fetches user by ID
-func (r *UserRepo) FindUserByID(id uuid.UUID) (*dto.UserResponse, *dto.ErrorResponse) {
+func (r *UserRepo) FindUserByID(ctx context.Context, id uuid.UUID) (*dto.UserResponse, *dto.ErrorResponse) {
 	logger.Logger().Debug("Finding user item", zap.String("email", id.String()))
-	sqlQuery := "SELECT id,first_name,last_name,email FROM users WHERE id=$1"
+	sqlQuery := "SELECT id,first_name,last_name,email,totp_confirmed,role FROM users WHERE id=$1 AND deleted_at IS NULL AND " + userInTenant(2)
 	var rows *sql.Rows
 	var err error
-	if rows, err = r.DB.QueryContext(context.Background(), sqlQuery, id); err != nil {
+	if rows, err = r.DB.QueryContext(ctx, sqlQuery, id, tenantID(ctx)); err != nil {
 		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed find user"}
 	}
 
@@ -58,7 +145,7 @@ func (r *UserRepo) FindUserByID(id uuid.UUID) (*dto.UserResponse, *dto.ErrorResp
 
 	response := dto.UserResponse{}
 	if rows.Next() {
-		err = rows.Scan(&response.ID, &response.FirstName, &response.LastName, &response.Email)
+		err = rows.Scan(&response.ID, &response.FirstName, &response.LastName, &response.Email, &response.IsUsing2FA, &response.Role)
 		if err != nil {
 			return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: err, Message: fmt.Sprintf("User [%s] not found", id)}
 		}
@@ -72,12 +159,13 @@ func (r *UserRepo) FindUserByID(id uuid.UUID) (*dto.UserResponse, *dto.ErrorResp
 }
 
 // FindUserByEmail fetches user by email
-func (r *UserRepo) FindUserByEmail(email string) (*dto.UserResponse, *dto.ErrorResponse) {
+func (r *UserRepo) FindUserByEmail(ctx context.Context, email string) (*dto.UserResponse, *dto.ErrorResponse) {
 	logger.Logger().Debug("Finding user item", zap.String("email", email))
-	sqlQuery := "SELECT id,first_name,last_name,email,is_2fa FROM users WHERE email=$1"
+	email = dto.NormalizeEmail(email)
+	sqlQuery := "SELECT id,first_name,last_name,email,totp_confirmed,role FROM users WHERE lower(email)=$1 AND deleted_at IS NULL AND " + userInTenant(2)
 	var rows *sql.Rows
 	var err error
-	if rows, err = r.DB.QueryContext(context.Background(), sqlQuery, email); err != nil {
+	if rows, err = r.DB.QueryContext(ctx, sqlQuery, email, tenantID(ctx)); err != nil {
 		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed find user"}
 	}
 
@@ -85,7 +173,7 @@ func (r *UserRepo) FindUserByEmail(email string) (*dto.UserResponse, *dto.ErrorR
 
 	response := dto.UserResponse{}
 	if rows.Next() {
-		err = rows.Scan(&response.ID, &response.FirstName, &response.LastName, &response.Email, &response.IsUsing2FA)
+		err = rows.Scan(&response.ID, &response.FirstName, &response.LastName, &response.Email, &response.IsUsing2FA, &response.Role)
 		if err != nil {
 			return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: err, Message: fmt.Sprintf("User [%s] not found", email)}
 		}
@@ -100,21 +188,35 @@ func (r *UserRepo) FindUserByEmail(email string) (*dto.UserResponse, *dto.ErrorR
 // GetUser gets user from DB for given email
 func (r *UserRepo) GetUser(email string) (*dto.User, *dto.ErrorResponse) {
 	logger.Logger().Debug("Finding user item", zap.String("email", email))
-	sqlQuery := "SELECT email,password FROM users WHERE email=$1"
+	sqlQuery := "SELECT id,email,password,totp_confirmed,role,email_verified_at IS NOT NULL,failed_logins,last_failed_login FROM users WHERE lower(email)=$1 AND deleted_at IS NULL"
+	return r.getUser(sqlQuery, dto.NormalizeEmail(email))
+}
+
+// GetUserByID gets user from DB for given id
+func (r *UserRepo) GetUserByID(id uuid.UUID) (*dto.User, *dto.ErrorResponse) {
+	logger.Logger().Debug("Finding user item", zap.String("id", id.String()))
+	sqlQuery := "SELECT id,email,password,totp_confirmed,role,email_verified_at IS NOT NULL,failed_logins,last_failed_login FROM users WHERE id=$1 AND deleted_at IS NULL"
+	return r.getUser(sqlQuery, id)
+}
+
+// getUser runs a single user query and scans it into db user struct
+func (r *UserRepo) getUser(sqlQuery string, arg interface{}) (*dto.User, *dto.ErrorResponse) {
 	var rows *sql.Rows
 	var err error
-	if rows, err = r.DB.Query(sqlQuery, email); err != nil {
+	if rows, err = r.DB.Query(sqlQuery, arg); err != nil {
 		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed find user"}
 	}
 
 	defer rows.Close()
 
 	response := dto.User{}
+	var lastFailedLogin sql.NullTime
 	if rows.Next() {
-		err = rows.Scan(&response.Email, &response.Password)
+		err = rows.Scan(&response.ID, &response.Email, &response.Password, &response.IsUsing2FA, &response.Role, &response.EmailVerified, &response.FailedLogins, &lastFailedLogin)
 		if err != nil {
-			return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: err, Message: fmt.Sprintf("User [%s] not found", email)}
+			return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: err, Message: fmt.Sprintf("User [%v] not found", arg)}
 		}
+		response.LastFailedLogin = lastFailedLogin.Time
 		return &response, nil
 	}
 	if err = rows.Err(); err != nil {
@@ -123,47 +225,412 @@ func (r *UserRepo) GetUser(email string) (*dto.User, *dto.ErrorResponse) {
 	return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: fmt.Errorf("not found"), Message: "user not found"}
 }
 
-// GetUser gets user from DB for given email
-func (r *UserRepo) GetUsers() ([]dto.UserResponse, *dto.ErrorResponse) {
-	sqlQuery := "SELECT id,first_name,last_name,email,is_2fa FROM users"
-	var rows *sql.Rows
+// userSortColumns maps sort fields to the expressions ordering users, the
+// creation date of old users may be missing and sorts first
+var userSortColumns = map[string]string{
+	"created":    "COALESCE(created,'-infinity'::date)",
+	"email":      "email",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+}
 
-	var err error
-	if rows, err = r.DB.Query(sqlQuery); err != nil {
+// userSortCasts converts the text of cursor values back to the type of their sort column
+var userSortCasts = map[string]string{
+	"created": "::date",
+}
+
+// userCursor is the position after the last user of a page, encoded into an opaque string
+type userCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// GetUsers fetches a page of the users matching request, ordered by the sort
+// field and id so pages stay stable while users are added
+func (r *UserRepo) GetUsers(ctx context.Context, request dto.UserListRequest) (*dto.UserListResponse, *dto.ErrorResponse) {
+	field, desc := request.SortField()
+	column, ok := userSortColumns[field]
+	if !ok {
+		return nil, &dto.ErrorResponse{Status: http.StatusBadRequest, Error: fmt.Errorf("unknown sort field %q", field), Message: "Validation error"}
+	}
+
+	conditions, args := userListFilter(ctx, request)
+	response := &dto.UserListResponse{Data: []dto.UserResponse{}}
+	filter := ""
+	if len(conditions) > 0 {
+		filter = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if err := r.DB.QueryRowContext(ctx, "SELECT count(*) FROM users"+filter, args...).Scan(&response.Total); err != nil {
 		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed Get users"}
 	}
 
+	// keyset pagination continues after the row the cursor points to
+	order, compare := "ASC", ">"
+	if desc {
+		order, compare = "DESC", "<"
+	}
+	if request.Cursor != "" {
+		cursor, err := decodeUserCursor(request.Cursor)
+		if err == nil {
+			_, err = uuid.FromString(cursor.ID)
+		}
+		if err != nil || cursor.Sort != request.Sort {
+			return nil, &dto.ErrorResponse{Status: http.StatusBadRequest, Error: err, Message: "Invalid cursor"}
+		}
+		args = append(args, cursor.Value, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s,id)%s($%d%s,$%d)", column, compare, len(args)-1, userSortCasts[field], len(args)))
+	}
+	if len(conditions) > 0 {
+		filter = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, request.Limit+1)
+	sqlQuery := fmt.Sprintf("SELECT id,first_name,last_name,email,totp_confirmed,role,deleted_at,(%s)::text FROM users%s ORDER BY %s %s,id %s LIMIT $%d",
+		column, filter, column, order, order, len(args))
+
+	rows, err := r.DB.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed Get users"}
+	}
 	defer rows.Close()
-	var users []dto.UserResponse
+	var last userCursor
 	for rows.Next() {
-		var response dto.UserResponse
-		err = rows.Scan(&response.ID,&response.FirstName, &response.LastName, &response.Email, &response.IsUsing2FA)
-		if err != nil {
-			return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: err, Message: "Failed to fetch users"}
+		if len(response.Data) == request.Limit {
+			// the extra row only tells there is another page
+			if response.NextCursor, err = encodeUserCursor(last); err != nil {
+				return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch users"}
+			}
+			break
+		}
+		var user dto.UserResponse
+		if err = rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.IsUsing2FA, &user.Role, &user.DeletedAt, &last.Value); err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch users"}
 		}
-		users = append(users,response)
+		last.Sort, last.ID = request.Sort, user.ID
+		response.Data = append(response.Data, user)
 	}
-	
 	if err = rows.Err(); err != nil {
-		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch user"}
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to fetch users"}
+	}
+	return response, nil
+}
+
+// ExportUsers calls fn with every user matching the filters of request in its
+// sort order, one at a time so exports of any size are streamed
+func (r *UserRepo) ExportUsers(ctx context.Context, request dto.UserListRequest, fn func(user dto.UserResponse) error) error {
+	field, desc := request.SortField()
+	column, ok := userSortColumns[field]
+	if !ok {
+		return fmt.Errorf("unknown sort field %q", field)
+	}
+	order := "ASC"
+	if desc {
+		order = "DESC"
+	}
+	conditions, args := userListFilter(ctx, request)
+	filter := ""
+	if len(conditions) > 0 {
+		filter = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery := fmt.Sprintf("SELECT id,first_name,last_name,email,totp_confirmed,role,deleted_at FROM users%s ORDER BY %s %s,id %s",
+		filter, column, order, order)
+
+	rows, err := r.DB.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return err
 	}
-	return users, nil
+	defer rows.Close()
+	for rows.Next() {
+		var user dto.UserResponse
+		if err = rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.IsUsing2FA, &user.Role, &user.DeletedAt); err != nil {
+			return err
+		}
+		if err = fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
-func hashAndSalt(pwd []byte) string {
-	hash, err := bcrypt.GenerateFromPassword(pwd, bcrypt.MinCost)
+// userListFilter returns the conditions and their args selecting the users request
+// filters for among the members of the tenant of ctx
+func userListFilter(ctx context.Context, request dto.UserListRequest) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+	if request.Email != "" {
+		addCondition("email ILIKE ?", containsPattern(request.Email))
+	}
+	if request.Name != "" {
+		addCondition("(first_name ILIKE ? OR last_name ILIKE ? OR first_name || ' ' || last_name ILIKE ?)", containsPattern(request.Name))
+	}
+	if request.IsUsing2FA != nil {
+		addCondition("totp_confirmed=?", *request.IsUsing2FA)
+	}
+	if request.CreatedFrom != nil {
+		addCondition("created>=?", *request.CreatedFrom)
+	}
+	if request.CreatedTo != nil {
+		addCondition("created<=?", *request.CreatedTo)
+	}
+	if !request.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if tenant := tenantID(ctx); tenant.Valid {
+		args = append(args, tenant)
+		conditions = append(conditions, userInTenant(len(args)))
+	}
+	return conditions, args
+}
+
+// containsPattern creates an ILIKE pattern matching value anywhere, wildcards in value match literally
+func containsPattern(value string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value) + "%"
+}
+
+// encodeUserCursor encodes cursor into an opaque url safe string
+func encodeUserCursor(cursor userCursor) (string, error) {
+	data, err := json.Marshal(cursor)
 	if err != nil {
-		log.Println(err)
+		return "", err
 	}
-	return string(hash)
+	return base64.RawURLEncoding.EncodeToString(data), nil
 }
 
-//DeleteUser delete user
-func (r *UserRepo) DeleteUser(id string) *dto.ErrorResponse {
-	_, err := r.DB.Exec("DELETE FROM users WHERE id=$1;", id)
+// decodeUserCursor decodes a cursor created by encodeUserCursor
+func decodeUserCursor(value string) (userCursor, error) {
+	var cursor userCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+//DeleteUser marks user deleted, it is purged once the retention passed
+func (r *UserRepo) DeleteUser(ctx context.Context, id string) *dto.ErrorResponse {
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed delete user"}
 	}
+	defer tx.Rollback()
 
+	result, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at=$1 WHERE id=$2 AND deleted_at IS NULL AND "+userInTenant(3), time.Now(), id, tenantID(ctx))
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed delete user"}
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "user not found"}
+	}
+	if err = recordAudit(ctx, tx, "delete", "users", id); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed delete user"}
+	}
+	if err = tx.Commit(); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed delete user"}
+	}
+
+	return nil
+}
+
+// RestoreUser undoes the deletion of user
+func (r *UserRepo) RestoreUser(ctx context.Context, id string) *dto.ErrorResponse {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to restore user"}
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRowContext(ctx, "SELECT email FROM users WHERE id=$1 AND deleted_at IS NOT NULL AND "+userInTenant(2)+" FOR UPDATE;", id, tenantID(ctx)).Scan(&email)
+	if err == sql.ErrNoRows {
+		return &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "deleted user not found"}
+	}
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to restore user"}
+	}
+	// the address may have been registered again after the deletion
+	var taken bool
+	if err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE lower(email)=lower($1) AND deleted_at IS NULL);", email).Scan(&taken); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to restore user"}
+	}
+	if taken {
+		return &dto.ErrorResponse{Status: http.StatusConflict, Error: nil, Message: "Conflict Email"}
+	}
+	if _, err = tx.ExecContext(ctx, "UPDATE users SET deleted_at=NULL WHERE id=$1;", id); isUniqueViolation(err) {
+		return &dto.ErrorResponse{Status: http.StatusConflict, Error: err, Message: "Conflict Email"}
+	} else if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to restore user"}
+	}
+	if err = recordAudit(ctx, tx, "restore", "users", id); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to restore user"}
+	}
+	if err = tx.Commit(); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to restore user"}
+	}
+	return nil
+}
+
+// PurgeDeletedUsers removes users deleted before the given time with their data and returns how many
+func (r *UserRepo) PurgeDeletedUsers(ctx context.Context, before time.Time) (int, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "DELETE FROM users WHERE deleted_at<$1 returning id;", before)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err = recordAudit(ctx, tx, "purge", "users", id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), tx.Commit()
+}
+
+// UpdateUser replaces the profile of user, a changed email address has to be verified again
+func (r *UserRepo) UpdateUser(ctx context.Context, id string, request dto.UserUpdateRequest) (*dto.UserResponse, *dto.ErrorResponse) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to update user"}
+	}
+	defer tx.Rollback()
+
+	sqlQuery := `UPDATE users SET first_name=$1,last_name=$2,email=$3,updated=$4,
+		email_verified_at=CASE WHEN lower(email)=$3 THEN email_verified_at END
+		WHERE id=$5 AND deleted_at IS NULL AND ` + userInTenant(6) + ` returning id,first_name,last_name,email,totp_confirmed,role;`
+	response := dto.UserResponse{}
+	request.Email = dto.NormalizeEmail(request.Email)
+	err = tx.QueryRowContext(ctx, sqlQuery, request.FirstName, request.LastName, request.Email, time.Now(), id, tenantID(ctx)).
+		Scan(&response.ID, &response.FirstName, &response.LastName, &response.Email, &response.IsUsing2FA, &response.Role)
+	if err == sql.ErrNoRows {
+		return nil, &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "user not found"}
+	}
+	if isUniqueViolation(err) {
+		return nil, &dto.ErrorResponse{Status: http.StatusConflict, Error: err, Message: "Conflict Email"}
+	}
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to update user"}
+	}
+	if err = recordAudit(ctx, tx, "update", "users", id); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to update user"}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to update user"}
+	}
+	return &response, nil
+}
+
+// SetUserRole changes the role of user
+func (r *UserRepo) SetUserRole(ctx context.Context, id string, role string) *dto.ErrorResponse {
+	result, err := r.DB.ExecContext(ctx, "UPDATE users SET role=$1 WHERE id=$2 AND deleted_at IS NULL AND "+userInTenant(3), role, id, tenantID(ctx))
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to update role"}
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "user not found"}
+	}
+	return nil
+}
+
+// UpdatePassword hashes and stores a new password for user
+func (r *UserRepo) UpdatePassword(ctx context.Context, id string, password string) *dto.ErrorResponse {
+	hash, err := r.Hasher.Hash(password)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to update password"}
+	}
+	result, err := r.DB.ExecContext(ctx, "UPDATE users SET password=$1 WHERE id=$2 AND deleted_at IS NULL;", hash, id)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to update password"}
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "user not found"}
+	}
+	return nil
+}
+
+// RehashPassword replaces the stored hash of user with one made with the current
+// parameters, unless the password was changed since oldHash was read
+func (r *UserRepo) RehashPassword(ctx context.Context, id string, oldHash string, password string) *dto.ErrorResponse {
+	hash, err := r.Hasher.Hash(password)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to rehash password"}
+	}
+	_, err = r.DB.ExecContext(ctx, "UPDATE users SET password=$1 WHERE id=$2 AND password=$3;", hash, id, oldHash)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to rehash password"}
+	}
+	return nil
+}
+
+// SetEmailVerified marks the email address of user as verified
+func (r *UserRepo) SetEmailVerified(ctx context.Context, id string) *dto.ErrorResponse {
+	_, err := r.DB.ExecContext(ctx, "UPDATE users SET email_verified_at=$1 WHERE id=$2 AND email_verified_at IS NULL;", time.Now(), id)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to verify email"}
+	}
+	return nil
+}
+
+// RecordFailedLogin counts a failed login of user, failures older than resetBefore are forgotten
+func (r *UserRepo) RecordFailedLogin(ctx context.Context, id string, resetBefore time.Time) (int, *dto.ErrorResponse) {
+	sqlQuery := `UPDATE users SET failed_logins=CASE WHEN last_failed_login IS NULL OR last_failed_login<$1 THEN 1 ELSE failed_logins+1 END,
+		last_failed_login=$2 WHERE id=$3 returning failed_logins;`
+	var failures int
+	if err := r.DB.QueryRowContext(ctx, sqlQuery, resetBefore, time.Now(), id).Scan(&failures); err != nil {
+		return 0, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to record login attempt"}
+	}
+	return failures, nil
+}
+
+// ResetFailedLogins forgets the failed logins of user after a successful login
+func (r *UserRepo) ResetFailedLogins(ctx context.Context, id string) *dto.ErrorResponse {
+	_, err := r.DB.ExecContext(ctx, "UPDATE users SET failed_logins=0,last_failed_login=NULL WHERE id=$1 AND failed_logins>0;", id)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to record login attempt"}
+	}
+	return nil
+}
+
+// UnlockUser lifts a lockout caused by failed logins
+func (r *UserRepo) UnlockUser(ctx context.Context, id string) *dto.ErrorResponse {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to unlock user"}
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE users SET failed_logins=0,last_failed_login=NULL WHERE id=$1 AND deleted_at IS NULL AND "+userInTenant(2), id, tenantID(ctx))
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to unlock user"}
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &dto.ErrorResponse{Status: http.StatusNotFound, Error: dto.NotFoundError, Message: "user not found"}
+	}
+	if err = recordAudit(ctx, tx, "unlock", "users", id); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to unlock user"}
+	}
+	if err = tx.Commit(); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to unlock user"}
+	}
 	return nil
 }
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+)
+
+func TestUserCursorRoundTrip(t *testing.T) {
+	cursors := []userCursor{
+		{Sort: "email", Value: "jane@example.com", ID: "6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f"},
+		{Sort: "-created", Value: "-infinity", ID: "6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f"},
+		{Sort: "last_name", Value: "Ünal/O'Brien+?&", ID: "6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f"},
+		{},
+	}
+	for _, cursor := range cursors {
+		encoded, err := encodeUserCursor(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.ContainsAny(encoded, "+/=?&") {
+			t.Errorf("cursor %q is not url safe", encoded)
+		}
+		decoded, err := decodeUserCursor(encoded)
+		if err != nil {
+			t.Fatalf("decode %q: %v", encoded, err)
+		}
+		if decoded != cursor {
+			t.Errorf("decoded %+v, want %+v", decoded, cursor)
+		}
+	}
+}
+
+func TestDecodeUserCursorRejectsInvalidCursors(t *testing.T) {
+	for _, value := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("not json")),
+		base64.RawURLEncoding.EncodeToString([]byte(`["email","x","id"]`)),
+	} {
+		if _, err := decodeUserCursor(value); err == nil {
+			t.Errorf("cursor %q accepted", value)
+		}
+	}
+}
+
+func TestUserListFilter(t *testing.T) {
+	enabled := true
+	request := dto.UserListRequest{Email: "example", Name: "jane", IsUsing2FA: &enabled}
+	conditions, args := userListFilter(context.Background(), request)
+	wantConditions := []string{
+		"email ILIKE $1",
+		"(first_name ILIKE $2 OR last_name ILIKE $2 OR first_name || ' ' || last_name ILIKE $2)",
+		"totp_confirmed=$3",
+		"deleted_at IS NULL",
+	}
+	wantArgs := []interface{}{"%example%", "%jane%", true}
+	if !reflect.DeepEqual(conditions, wantConditions) || !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("userListFilter = %q %v, want %q %v", conditions, args, wantConditions, wantArgs)
+	}
+}
+
+func TestUserListFilterTenant(t *testing.T) {
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{UserID: "user", TenantID: "org"})
+	conditions, args := userListFilter(ctx, dto.UserListRequest{IncludeDeleted: true})
+	if len(conditions) != 1 || conditions[0] != userInTenant(1) {
+		t.Errorf("conditions = %q, want the tenant condition", conditions)
+	}
+	if len(args) != 1 || args[0] != tenantID(ctx) {
+		t.Errorf("args = %v, want the tenant", args)
+	}
+}
+
+func TestContainsPattern(t *testing.T) {
+	tests := map[string]string{
+		"jane":      "%jane%",
+		"100%":      `%100\%%`,
+		"first_one": `%first\_one%`,
+		`back\`:     `%back\\%`,
+	}
+	for value, want := range tests {
+		if pattern := containsPattern(value); pattern != want {
+			t.Errorf("containsPattern(%q) = %q, want %q", value, pattern, want)
+		}
+	}
+}
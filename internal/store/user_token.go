@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+)
+
+// Purposes of single use user tokens
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeMagicLink         = "magic_link"
+)
+
+// UserTokenRepo stores single use, expiring tokens sent to users
+type UserTokenRepo struct {
+	DB *sql.DB
+}
+
+// CreateUserToken stores a token for purpose and invalidates older unused tokens of the same purpose
+func (r *UserTokenRepo) CreateUserToken(ctx context.Context, userID string, purpose string, tokenHash string, expires time.Time) *dto.ErrorResponse {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create token"}
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "UPDATE user_tokens SET used=$1 WHERE user_id=$2 AND purpose=$3 AND used IS NULL;", time.Now(), userID, purpose); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create token"}
+	}
+	sqlQuery := "INSERT INTO user_tokens(user_id,purpose,token_hash,expires,created) VALUES($1,$2,$3,$4,$5);"
+	if _, err = tx.ExecContext(ctx, sqlQuery, userID, purpose, tokenHash, expires, time.Now()); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create token"}
+	}
+	if err = tx.Commit(); err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create token"}
+	}
+	return nil
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns its user id
+func (r *UserTokenRepo) ConsumeUserToken(ctx context.Context, purpose string, tokenHash string) (string, *dto.ErrorResponse) {
+	sqlQuery := "UPDATE user_tokens SET used=$1 WHERE token_hash=$2 AND purpose=$3 AND used IS NULL AND expires>$1 returning user_id;"
+	var userID string
+	err := r.DB.QueryRowContext(ctx, sqlQuery, time.Now(), tokenHash, purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", &dto.ErrorResponse{Status: http.StatusBadRequest, Error: dto.NotFoundError, Message: "Invalid or expired token"}
+	}
+	if err != nil {
+		return "", &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to verify token"}
+	}
+	return userID, nil
+}
+
+// PeekUserToken returns the user of a valid token without using it up
+func (r *UserTokenRepo) PeekUserToken(ctx context.Context, purpose string, tokenHash string) (string, *dto.ErrorResponse) {
+	sqlQuery := "SELECT user_id FROM user_tokens WHERE token_hash=$1 AND purpose=$2 AND used IS NULL AND expires>$3;"
+	var userID string
+	err := r.DB.QueryRowContext(ctx, sqlQuery, tokenHash, purpose, time.Now()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", &dto.ErrorResponse{Status: http.StatusBadRequest, Error: dto.NotFoundError, Message: "Invalid or expired token"}
+	}
+	if err != nil {
+		return "", &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to verify token"}
+	}
+	return userID, nil
+}
@@ -0,0 +1,121 @@
+// Package throttle slows down repeated failed attempts with exponential backoff
+// and locks out keys exceeding a threshold for a while.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy describes backoff and lockout after failed attempts
+type Policy struct {
+	// failures allowed before backoff starts
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// failures after which attempts are locked out, zero disables lockout
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// failures are forgotten after this long without a new one
+	ResetAfter time.Duration
+}
+
+// Delay returns how long to wait after the last of failures consecutive failed attempts
+func (p Policy) Delay(failures int) time.Duration {
+	if p.LockoutThreshold > 0 && failures >= p.LockoutThreshold {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// RetryAfter returns how long to wait at now after failures, the last one at lastFailure
+func (p Policy) RetryAfter(failures int, lastFailure time.Time, now time.Time) time.Duration {
+	if failures == 0 || now.Sub(lastFailure) > p.ResetAfter {
+		return 0
+	}
+	if wait := lastFailure.Add(p.Delay(failures)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Locked reports whether failures reached the lockout threshold
+func (p Policy) Locked(failures int) bool {
+	return p.LockoutThreshold > 0 && failures >= p.LockoutThreshold
+}
+
+// Tracker counts failed attempts per key in memory
+type Tracker struct {
+	policy Policy
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	swept   time.Time
+}
+
+type entry struct {
+	failures int
+	last     time.Time
+}
+
+// NewTracker creates a tracker applying policy
+func NewTracker(policy Policy) *Tracker {
+	return &Tracker{policy: policy, entries: map[string]*entry{}}
+}
+
+// RetryAfter returns how long key has to wait before its next attempt
+func (t *Tracker) RetryAfter(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key]
+	if !ok {
+		return 0
+	}
+	return t.policy.RetryAfter(e.failures, e.last, now)
+}
+
+// Fail records a failed attempt of key and returns how long it has to wait now
+func (t *Tracker) Fail(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(now)
+
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.last) > t.policy.ResetAfter {
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+	return t.policy.RetryAfter(e.failures, e.last, now)
+}
+
+// Reset forgets the failed attempts of key
+func (t *Tracker) Reset(key string) {
+	t.mu.Lock()
+	delete(t.entries, key)
+	t.mu.Unlock()
+}
+
+// sweep drops forgotten entries so memory does not grow with every key ever seen
+func (t *Tracker) sweep(now time.Time) {
+	if now.Sub(t.swept) < t.policy.ResetAfter {
+		return
+	}
+	for key, e := range t.entries {
+		if now.Sub(e.last) > t.policy.ResetAfter {
+			delete(t.entries, key)
+		}
+	}
+	t.swept = now
+}
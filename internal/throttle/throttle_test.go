@@ -0,0 +1,168 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:     2,
+	BaseDelay:        time.Second,
+	MaxDelay:         10 * time.Second,
+	LockoutThreshold: 8,
+	LockoutDuration:  15 * time.Minute,
+	ResetAfter:       time.Hour,
+}
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{8, 15 * time.Minute},
+		{100, 15 * time.Minute},
+	}
+	for _, test := range tests {
+		if delay := testPolicy.Delay(test.failures); delay != test.delay {
+			t.Errorf("Delay(%d) = %s, want %s", test.failures, delay, test.delay)
+		}
+	}
+}
+
+func TestDelayWithoutLockout(t *testing.T) {
+	policy := testPolicy
+	policy.LockoutThreshold = 0
+	for _, failures := range []int{7, 8, 100, 10000} {
+		if delay := policy.Delay(failures); delay != policy.MaxDelay {
+			t.Errorf("Delay(%d) = %s, want %s", failures, delay, policy.MaxDelay)
+		}
+		if policy.Locked(failures) {
+			t.Errorf("Locked(%d) without lockout threshold", failures)
+		}
+	}
+}
+
+func TestLocked(t *testing.T) {
+	tests := map[int]bool{0: false, 7: false, 8: true, 9: true}
+	for failures, locked := range tests {
+		if testPolicy.Locked(failures) != locked {
+			t.Errorf("Locked(%d) = %v, want %v", failures, !locked, locked)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	last := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		failures int
+		now      time.Time
+		wait     time.Duration
+	}{
+		{"no failures", 0, last, 0},
+		{"free attempt", 2, last, 0},
+		{"backoff", 4, last.Add(500 * time.Millisecond), 1500 * time.Millisecond},
+		{"backoff passed", 4, last.Add(2 * time.Second), 0},
+		{"locked out", 8, last.Add(time.Minute), 14 * time.Minute},
+		{"lockout passed", 8, last.Add(15 * time.Minute), 0},
+		{"forgotten", 8, last.Add(time.Hour + time.Second), 0},
+	}
+	for _, test := range tests {
+		if wait := testPolicy.RetryAfter(test.failures, last, test.now); wait != test.wait {
+			t.Errorf("%s: RetryAfter = %s, want %s", test.name, wait, test.wait)
+		}
+	}
+
+	// a lockout longer than the reset period ends with it
+	policy := testPolicy
+	policy.LockoutDuration = 2 * time.Hour
+	if wait := policy.RetryAfter(8, last, last.Add(time.Hour+time.Second)); wait != 0 {
+		t.Errorf("RetryAfter after reset = %s, want 0", wait)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker(testPolicy)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if wait := tracker.RetryAfter("key", now); wait != 0 {
+		t.Errorf("unknown key waits %s", wait)
+	}
+
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 15 * time.Minute}
+	for i, delay := range want {
+		if wait := tracker.Fail("key", now); wait != delay {
+			t.Errorf("failure %d waits %s, want %s", i+1, wait, delay)
+		}
+		if wait := tracker.RetryAfter("key", now); wait != delay {
+			t.Errorf("after failure %d RetryAfter = %s, want %s", i+1, wait, delay)
+		}
+	}
+	if wait := tracker.RetryAfter("other", now); wait != 0 {
+		t.Errorf("other key waits %s", wait)
+	}
+}
+
+func TestTrackerForgetsAfterResetPeriod(t *testing.T) {
+	tracker := NewTracker(testPolicy)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		tracker.Fail("key", now)
+	}
+	later := now.Add(testPolicy.ResetAfter + time.Second)
+	if wait := tracker.RetryAfter("key", later); wait != 0 {
+		t.Errorf("RetryAfter after reset period = %s, want 0", wait)
+	}
+	// counting starts over, the failure is a free attempt again
+	if wait := tracker.Fail("key", later); wait != 0 {
+		t.Errorf("first failure after reset period waits %s, want 0", wait)
+	}
+	if failures := tracker.entries["key"].failures; failures != 1 {
+		t.Errorf("failures = %d, want 1", failures)
+	}
+}
+
+func TestTrackerSweepsForgottenKeys(t *testing.T) {
+	tracker := NewTracker(testPolicy)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tracker.Fail("old", now)
+	tracker.Fail("recent", now.Add(testPolicy.ResetAfter))
+	// sweeping happens at most once per reset period
+	tracker.Fail("new", now.Add(testPolicy.ResetAfter+time.Second))
+	if _, ok := tracker.entries["old"]; !ok {
+		t.Error("swept again before the reset period passed")
+	}
+	tracker.Fail("new", now.Add(2*testPolicy.ResetAfter))
+	if _, ok := tracker.entries["old"]; ok {
+		t.Error("forgotten key kept")
+	}
+	if _, ok := tracker.entries["recent"]; !ok {
+		t.Error("recent key dropped")
+	}
+}
+
+func TestTrackerReset(t *testing.T) {
+	tracker := NewTracker(testPolicy)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 8; i++ {
+		tracker.Fail("key", now)
+	}
+	tracker.Fail("other", now)
+	tracker.Reset("key")
+	if wait := tracker.RetryAfter("key", now); wait != 0 {
+		t.Errorf("RetryAfter after success = %s, want 0", wait)
+	}
+	if wait := tracker.Fail("key", now); wait != 0 {
+		t.Errorf("failure after success waits %s, want a free attempt", wait)
+	}
+	if _, ok := tracker.entries["other"]; !ok {
+		t.Error("success cleared another key")
+	}
+}
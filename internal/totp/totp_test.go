@@ -0,0 +1,121 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// secret of the RFC 6238 test vectors, "12345678901234567890" base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// SHA1 vectors of RFC 6238 appendix B, truncated to six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Errorf("Code at %d = %s, want %s", test.unix, code, test.code)
+		}
+	}
+}
+
+func TestCodeAcceptsFormattedSecret(t *testing.T) {
+	formatted := strings.ToLower(rfcSecret[:16]) + " " + rfcSecret[16:]
+	code, err := Code(formatted, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "287082" {
+		t.Errorf("Code = %s, want 287082", code)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	tests := []struct {
+		name  string
+		step  int64
+		valid bool
+	}{
+		{"current", current, true},
+		{"previous", current - 1, true},
+		{"next", current + 1, true},
+		{"too old", current - 2, false},
+		{"too new", current + 2, false},
+	}
+	for _, test := range tests {
+		code, err := Code(rfcSecret, test.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		if ok != test.valid {
+			t.Errorf("%s: valid = %v, want %v", test.name, ok, test.valid)
+		}
+		if ok && step != test.step {
+			t.Errorf("%s: step = %d, want %d", test.name, step, test.step)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	for _, code := range []string{"", "50471", "0504710", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	first, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("secrets repeat")
+	}
+	if key, err := encoding.DecodeString(first); err != nil || len(key) != 20 {
+		t.Errorf("secret %q does not decode to 20 bytes: %v", first, err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Acme Corp", "jane@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Acme Corp:jane@example.com" {
+		t.Errorf("uri %s has wrong scheme, type or label", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != rfcSecret || query.Get("issuer") != "Acme Corp" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("uri %s has wrong parameters", uri)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// CreateInvitation emails an expiring invitation link, the invitee chooses name
+// and password on accepting it or joins with an existing account. Whether the
+// address belongs to an account is not revealed. Admins can invite anyone, owners
+// and admins of an organization can invite members to the organization they act in.
+func (app *App) CreateInvitation(writer http.ResponseWriter, req *http.Request) {
+	principal, ok := auth.PrincipalFromContext(req.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	var request dto.InvitationRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if status, errValidate := request.ValidateInvitation(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+	if request.OrgID != "" {
+		id, err := uuid.FromString(request.OrgID)
+		if err != nil {
+			app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid organization id")
+			return
+		}
+		request.OrgID = id.String()
+	}
+	if !principal.HasAnyRole(dto.RoleAdmin) {
+		allowed := request.Role == dto.RoleUser && request.OrgID != "" && request.OrgID == principal.TenantID &&
+			(principal.TenantRole == dto.OrgRoleOwner || principal.TenantRole == dto.OrgRoleAdmin && request.OrgRole != dto.OrgRoleOwner)
+		if !allowed {
+			app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Insufficient permissions")
+			return
+		}
+	}
+
+	app.sendInvitation(writer, req, principal, request)
+}
+
+// sendInvitation stores the invitation of request made by principal and emails its link to the invitee
+func (app *App) sendInvitation(writer http.ResponseWriter, req *http.Request, principal *auth.Principal, request dto.InvitationRequest) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to create invitation")
+		return
+	}
+	expires := time.Now().Add(app.conf.AuthConfig.InvitationTTL)
+	response, errResponse := app.invitationRepo.CreateInvitation(req.Context(), request, hashToken(token), expires)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	app.sendMail(request.Email, "You have been invited to "+app.conf.AppName, app.invitationBody(token))
+	logger.Logger().Info("Invitation sent", zap.String("email", request.Email), zap.String("by", principal.Email))
+
+	// render output
+	app.RenderJSON(writer, http.StatusCreated, response)
+}
+
+// GetInvitations lists the pending invitations to the organization the caller acts in
+func (app *App) GetInvitations(writer http.ResponseWriter, req *http.Request) {
+	if !app.invitationManager(writer, req) {
+		return
+	}
+	invitations, errResponse := app.invitationRepo.GetInvitations(req.Context())
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, invitations)
+}
+
+// RevokeInvitation revokes a pending invitation, its link can not be accepted anymore
+func (app *App) RevokeInvitation(writer http.ResponseWriter, req *http.Request) {
+	if !app.invitationManager(writer, req) {
+		return
+	}
+	id, err := uuid.FromString(mux.Vars(req)["id"])
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid id")
+		return
+	}
+	if errResponse := app.invitationRepo.RevokeInvitation(req.Context(), id.String()); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, "")
+}
+
+// AcceptInvitation creates the invited user with the name and password chosen by the invitee
+func (app *App) AcceptInvitation(writer http.ResponseWriter, req *http.Request) {
+	var request dto.AcceptInvitationRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if status, errValidate := request.ValidateAcceptInvitation(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+
+	// the invitation is only used up once the password is accepted
+	invitation, errResponse := app.invitationRepo.PeekInvitation(req.Context(), hashToken(request.Token))
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if errResponse = app.checkPasswordPolicy(request.Password, invitation.Email); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	response, errResponse := app.invitationRepo.AcceptInvitation(req.Context(), hashToken(request.Token), request)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("Invitation accepted", zap.String("email", response.Email))
+
+	// render output
+	app.RenderJSON(writer, http.StatusCreated, response)
+}
+
+// JoinOrganization accepts an invitation to an organization sent to the address of
+// the authenticated user, who joins the organization with the role of the invitation
+func (app *App) JoinOrganization(writer http.ResponseWriter, req *http.Request) {
+	principal, ok := app.selfServicePrincipal(writer, req)
+	if !ok {
+		return
+	}
+	var request dto.JoinInvitationRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if status, errValidate := request.ValidateJoinInvitation(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+	user, errResponse := app.userRepo.GetUserByID(uuid.FromStringOrNil(principal.UserID))
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	invitation, errResponse := app.invitationRepo.PeekInvitation(req.Context(), hashToken(request.Token))
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if invitation.Email != dto.NormalizeEmail(user.Email) {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Invitation was sent to another address")
+		return
+	}
+	if invitation.OrgID == "" {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Invitation is not for an organization")
+		return
+	}
+
+	organization, errResponse := app.invitationRepo.JoinInvitation(req.Context(), hashToken(request.Token), user.ID, user.Email)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("Organization joined", zap.String("id", organization.ID), zap.String("email", user.Email))
+
+	// render output
+	app.RenderJSON(writer, http.StatusCreated, organization)
+}
+
+// invitationManager reports whether the caller may list and revoke invitations,
+// rendering an error if not. Admins and owners and admins of the organization
+// the caller acts in may.
+func (app *App) invitationManager(writer http.ResponseWriter, req *http.Request) bool {
+	principal, ok := auth.PrincipalFromContext(req.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return false
+	}
+	if !principal.HasAnyRole(dto.RoleAdmin) && principal.TenantRole != dto.OrgRoleOwner && principal.TenantRole != dto.OrgRoleAdmin {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Insufficient permissions")
+		return false
+	}
+	return true
+}
+
+// invitationBody creates the text of the invitation email
+func (app *App) invitationBody(token string) string {
+	validity := app.conf.AuthConfig.InvitationTTL.String()
+	if inviteURL := app.conf.AuthConfig.InvitationURL; inviteURL != "" {
+		link := inviteURL + "?token=" + url.QueryEscape(token)
+		return fmt.Sprintf("You have been invited to %s.\n\nOpen the link below to choose your name and password, it is valid for %s:\n%s\n\nIf you have an account already, log in before opening it to join with your account.\nIf you did not expect it you can ignore this email.\n", app.conf.AppName, validity, link)
+	}
+	return fmt.Sprintf("You have been invited to %s.\n\nUse the token below to choose your name and password, it is valid for %s:\n%s\n\nIf you have an account already, log in and use the token to join with your account.\nIf you did not expect it you can ignore this email.\n", app.conf.AppName, validity, token)
+}
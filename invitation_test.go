@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+)
+
+// invite calls CreateInvitation with body as an admin
+func (f *orgFixture) invite(body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/rap/invitations", strings.NewReader(body))
+	request = request.WithContext(auth.WithPrincipal(request.Context(), &auth.Principal{
+		UserID: f.userID("owner@example.com"), Email: "owner@example.com", Roles: []string{dto.RoleAdmin},
+	}))
+	recorder := httptest.NewRecorder()
+	f.app.CreateInvitation(recorder, request)
+	return recorder
+}
+
+func TestCreateInvitationSameResponseForExistingEmails(t *testing.T) {
+	f := newOrgFixture(t)
+	var responses []dto.InvitationResponse
+	for _, email := range []string{"jane@example.com", "nobody@example.com"} {
+		recorder := f.invite(`{"email":"` + email + `","org_id":"` + testOrgID + `"}`)
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("%s: status = %d, body %s", email, recorder.Code, recorder.Body)
+		}
+		f.invitationToken(t, email)
+		var response dto.InvitationResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		// only the address and times differ
+		response.Email, response.Created, response.Expires = "", time.Time{}, time.Time{}
+		responses = append(responses, response)
+	}
+	if responses[0] != responses[1] {
+		t.Errorf("existing email answered %+v, unknown one %+v", responses[0], responses[1])
+	}
+}
+
+func TestCreateInvitationExistingAccountJoins(t *testing.T) {
+	f := newOrgFixture(t)
+	f.invite(`{"email":"Jane@Example.com","org_id":"` + testOrgID + `","org_role":"member"}`)
+	token := f.invitationToken(t, "jane@example.com")
+
+	response := f.call(f.app.JoinOrganization, "jane@example.com", `{"token":"`+token+`"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if role := f.members[f.userID("jane@example.com")]; role != dto.OrgRoleMember {
+		t.Errorf("joined with role %q, want %q", role, dto.OrgRoleMember)
+	}
+}
+
+func TestAcceptInvitationCreatesUser(t *testing.T) {
+	f := newOrgFixture(t)
+	if response := f.invite(`{"email":"nobody@example.com","org_id":"` + testOrgID + `"}`); response.Code != http.StatusCreated {
+		t.Fatalf("invitation status = %d, body %s", response.Code, response.Body)
+	}
+	token := f.invitationToken(t, "nobody@example.com")
+
+	response := post(f.app.AcceptInvitation, `{"token":"`+token+`","first_name":"No","last_name":"Body","password":"Correct-Horse-42"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	userID := f.userID("nobody@example.com")
+	if userID == "" || f.members[userID] != dto.OrgRoleMember {
+		t.Errorf("invitee user %q joined with role %q, want %q", userID, f.members[userID], dto.OrgRoleMember)
+	}
+
+	again := post(f.app.AcceptInvitation, `{"token":"`+token+`","first_name":"No","last_name":"Body","password":"Correct-Horse-42"}`)
+	if again.Code != http.StatusBadRequest {
+		t.Errorf("reusing invitation status = %d, want %d", again.Code, http.StatusBadRequest)
+	}
+}
@@ -2,16 +2,12 @@ package main
 
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/mehmetkule/go-restapi/internal/dto"
 	"github.com/mehmetkule/go-restapi/logger"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 	"net/http"
-	"time"
-
-	jwt "github.com/dgrijalva/jwt-go"
-
 )
 
 // Login logs user to the server
@@ -24,37 +20,63 @@ func (app *App) Login(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 	logger.Logger().Info("Login for",zap.String("email",req.Email))
-	var user *dto.User
-	var errResponse *dto.ErrorResponse
-	if user, errResponse = app.userRepo.GetUser(req.Email); err != nil {
+	if app.loginThrottled(writer, request, nil) {
+		return
+	}
+	user, errResponse := app.userRepo.GetUser(req.Email)
+	if errResponse != nil {
+		if errResponse.Status == http.StatusNotFound {
+			app.recordLoginFailure(request.Context(), request, nil)
+		}
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
 	}
+	if app.loginThrottled(writer, request, user) {
+		return
+	}
 
-	if valid := ComparePasswords(user.Password, []byte(req.Password)); !valid {
+	if valid := app.ComparePasswords(request.Context(), user, req.Password); !valid {
+		app.recordLoginFailure(request.Context(), request, user)
 		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid Username/Password")
 		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"isUsing2FA": false,
-		"username":   user.Email,
-		"exp":        time.Now().Add(time.Minute * 1000000).Unix(),
-	})
+	if app.conf.AuthConfig.RequireVerifiedEmail && !user.EmailVerified {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Email address is not verified")
+		return
+	}
+
+	// failed logins are only forgotten once the second factor is verified too
+	if user.IsUsing2FA {
+		app.renderTwoFactorChallenge(writer, user)
+		return
+	}
+	app.recordLoginSuccess(request.Context(), user)
 
-	var tokenString string
-	if tokenString, err = token.SignedString([]byte(app.conf.PasswordKey)); err != nil {
-		app.RenderErrorResponse(writer, http.StatusForbidden, err, "Login Failed")
+	response, errResponse := app.issueTokens(request, user)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
 	}
-	app.RenderJSON(writer, http.StatusOK, dto.JWTToken{Token: tokenString})
+	app.RenderJSON(writer, http.StatusOK, response)
 }
 
-// ComparePasswords compare given passwordws
-func ComparePasswords(hashedPassword string, password []byte) bool {
-	byteHash := []byte(hashedPassword)
-	if err := bcrypt.CompareHashAndPassword(byteHash, password); err != nil {
+// ComparePasswords compares password with the stored hash of user and
+// transparently rehashes it when the hash uses weaker parameters than configured
+func (app *App) ComparePasswords(ctx context.Context, user *dto.User, password string) bool {
+	hasher := app.userRepo.Hasher
+	valid, err := hasher.Verify(user.Password, password)
+	if err != nil {
+		logger.Logger().Error("Failed to verify password", zap.String("email", user.Email), zap.Error(err))
 		return false
 	}
-	return true
+	if valid && hasher.NeedsRehash(user.Password) {
+		// login goes on with the old hash if rehashing fails
+		if errResponse := app.userRepo.RehashPassword(ctx, user.ID, user.Password, password); errResponse != nil {
+			logger.Logger().Error("Failed to rehash password", zap.String("email", user.Email), zap.Error(errResponse.Error))
+		} else {
+			logger.Logger().Info("Rehashed password", zap.String("email", user.Email))
+		}
+	}
+	return valid
 }
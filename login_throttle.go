@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// loginThrottled renders 429 when the client ip or the account has to wait before trying again
+func (app *App) loginThrottled(writer http.ResponseWriter, request *http.Request, user *dto.User) bool {
+	now := time.Now()
+	if wait := app.ipThrottle.RetryAfter(clientIP(request), now); wait > 0 {
+		app.renderRetryAfter(writer, wait, "Too many failed login attempts")
+		return true
+	}
+	if user == nil {
+		return false
+	}
+
+	policy := app.conf.AuthConfig.AccountThrottle.Policy()
+	if wait := policy.RetryAfter(user.FailedLogins, user.LastFailedLogin, now); wait > 0 {
+		message := "Too many failed login attempts"
+		if policy.Locked(user.FailedLogins) {
+			message = "Account is temporarily locked"
+		}
+		app.renderRetryAfter(writer, wait, message)
+		return true
+	}
+	return false
+}
+
+// mailThrottled renders 429 when the client ip has to wait or emails to address
+// were requested too often. Anonymous requests are counted whether the account
+// exists or not, so the limit reveals nothing.
+func (app *App) mailThrottled(writer http.ResponseWriter, request *http.Request, address string, message string) bool {
+	if app.loginThrottled(writer, request, nil) {
+		return true
+	}
+	now := time.Now()
+	throttleKey := dto.NormalizeEmail(address)
+	if wait := app.magicThrottle.RetryAfter(throttleKey, now); wait > 0 {
+		app.renderRetryAfter(writer, wait, message)
+		return true
+	}
+	app.magicThrottle.Fail(throttleKey, now)
+	return false
+}
+
+// recordLoginFailure counts a failed login for the client ip and the account if known
+func (app *App) recordLoginFailure(ctx context.Context, request *http.Request, user *dto.User) {
+	app.ipThrottle.Fail(clientIP(request), time.Now())
+	if user == nil {
+		return
+	}
+
+	policy := app.conf.AuthConfig.AccountThrottle.Policy()
+	failures, errResponse := app.userRepo.RecordFailedLogin(ctx, user.ID, time.Now().Add(-policy.ResetAfter))
+	if errResponse != nil {
+		logger.Logger().Error("Failed to record failed login", zap.String("email", user.Email), zap.Error(errResponse.Error))
+		return
+	}
+	if policy.Locked(failures) {
+		logger.Logger().Warn("Account locked after failed logins", zap.String("email", user.Email), zap.Int("failures", failures))
+	}
+}
+
+// recordLoginSuccess forgets the failed logins of the account
+func (app *App) recordLoginSuccess(ctx context.Context, user *dto.User) {
+	if user.FailedLogins == 0 {
+		return
+	}
+	if errResponse := app.userRepo.ResetFailedLogins(ctx, user.ID); errResponse != nil {
+		logger.Logger().Error("Failed to reset failed logins", zap.String("email", user.Email), zap.Error(errResponse.Error))
+	}
+}
+
+// UnlockUser lifts the lockout of an account
+func (app *App) UnlockUser(writer http.ResponseWriter, req *http.Request) {
+	params := mux.Vars(req)
+	id := params["id"]
+
+	if errResponse := app.userRepo.UnlockUser(req.Context(), id); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, "User unlocked")
+}
+
+// renderRetryAfter renders 429 with a Retry-After header in whole seconds
+func (app *App) renderRetryAfter(writer http.ResponseWriter, wait time.Duration, message string) {
+	writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	app.RenderErrorResponse(writer, http.StatusTooManyRequests, nil, message)
+}
+
+// clientIP returns the ip of the connected client
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// Logout revokes the access token and session of the request and optionally the given refresh token
+func (app *App) Logout(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	logger.Logger().Info("Logout for", zap.String("email", principal.Email))
+
+	// the refresh token is optional, an empty body only logs out the access token
+	var req dto.RefreshTokenRequest
+	if request.ContentLength != 0 {
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+			app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+			return
+		}
+	}
+
+	if errResponse := app.revocationRepo.RevokeToken(principal.TokenID, principal.UserID, principal.ExpiresAt); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if principal.SessionID != "" {
+		errResponse := app.revocationRepo.RevokeSession(request.Context(), principal.UserID, principal.SessionID)
+		if errResponse != nil && errResponse.Status != http.StatusNotFound {
+			app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+			return
+		}
+	}
+	if req.RefreshToken != "" {
+		if errResponse := app.refreshTokenRepo.RevokeRefreshToken(hashToken(req.RefreshToken)); errResponse != nil {
+			app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+			return
+		}
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, "Logout successful")
+}
+
+// RevokeAllSessions revokes every access token, refresh token and api key of a user
+func (app *App) RevokeAllSessions(writer http.ResponseWriter, request *http.Request) {
+	params := mux.Vars(request)
+	id := params["id"]
+
+	if errResponse := app.revokeAllSessions(id); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, "Sessions revoked")
+}
+
+// revokeAllSessions revokes issued access tokens, refresh tokens and api keys of
+// user, a stolen account must not keep programmatic access either
+func (app *App) revokeAllSessions(userID string) *dto.ErrorResponse {
+	if errResponse := app.refreshTokenRepo.RevokeUserRefreshTokens(userID); errResponse != nil {
+		return errResponse
+	}
+	if errResponse := app.apiKeyRepo.RevokeUserAPIKeys(userID); errResponse != nil {
+		return errResponse
+	}
+	return app.revocationRepo.RevokeUserTokens(userID)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/store"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// RequestMagicLink emails a single use login link to the user
+func (app *App) RequestMagicLink(writer http.ResponseWriter, req *http.Request) {
+	var request dto.MagicLinkRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if request.Email == "" {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Email is wrong")
+		return
+	}
+	if app.mailThrottled(writer, req, request.Email, "Too many login links requested") {
+		return
+	}
+
+	// the response is the same whether the account exists or not
+	const message = "If the account exists a login link has been sent"
+	user, errResponse := app.userRepo.GetUser(request.Email)
+	if errResponse != nil {
+		logger.Logger().Debug("Login link for unknown email")
+		app.RenderJSON(writer, http.StatusOK, message)
+		return
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to create login token")
+		return
+	}
+	expires := time.Now().Add(app.conf.AuthConfig.MagicLinkTTL)
+	if errResponse = app.userTokenRepo.CreateUserToken(req.Context(), user.ID, store.TokenPurposeMagicLink, hashToken(token), expires); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	app.sendMail(user.Email, "Your login link", app.magicLinkBody(token))
+	app.RenderJSON(writer, http.StatusOK, message)
+}
+
+// LoginMagicLink exchanges the token of a login link for the tokens Login issues,
+// the token is read from the query of the link or a json body posted by a frontend
+func (app *App) LoginMagicLink(writer http.ResponseWriter, req *http.Request) {
+	token := req.URL.Query().Get("token")
+	if token == "" && req.Method == http.MethodPost {
+		var request dto.MagicLinkLoginRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+			return
+		}
+		token = request.Token
+	}
+	if token == "" {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Token is not defined")
+		return
+	}
+	if app.loginThrottled(writer, req, nil) {
+		return
+	}
+
+	userID, errResponse := app.userTokenRepo.ConsumeUserToken(req.Context(), store.TokenPurposeMagicLink, hashToken(token))
+	if errResponse != nil {
+		app.recordLoginFailure(req.Context(), req, nil)
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	id, err := uuid.FromString(userID)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Login Failed")
+		return
+	}
+	user, errResponse := app.userRepo.GetUserByID(id)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	// a login link does not lift a lockout
+	if app.loginThrottled(writer, req, user) {
+		return
+	}
+
+	// opening the link proves the address belongs to the user
+	if !user.EmailVerified {
+		if errResponse = app.userRepo.SetEmailVerified(req.Context(), user.ID); errResponse != nil {
+			app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+			return
+		}
+		user.EmailVerified = true
+	}
+	logger.Logger().Info("Login link used", zap.String("email", user.Email))
+
+	if user.IsUsing2FA {
+		app.renderTwoFactorChallenge(writer, user)
+		return
+	}
+	app.recordLoginSuccess(req.Context(), user)
+
+	response, errResponse := app.issueTokens(req, user)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, response)
+}
+
+// magicLinkBody creates the text of the login link email
+func (app *App) magicLinkBody(token string) string {
+	link := app.conf.ServerConfig.PublicURL + "/login/magic/callback?token=" + url.QueryEscape(token)
+	if loginURL := app.conf.AuthConfig.MagicLinkURL; loginURL != "" {
+		link = loginURL + "?token=" + url.QueryEscape(token)
+	}
+	return fmt.Sprintf("A login link was requested for your %s account.\n\nOpen the link below to log in, it can be used once within %s:\n%s\n\nIf you did not request it you can ignore this email.\n", app.conf.AppName, app.conf.AuthConfig.MagicLinkTTL, link)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// sendMail sends an email in the background, so response times do not reveal whether an account exists
+func (app *App) sendMail(to string, subject string, body string) {
+	go func() {
+		if err := app.mailSender.Send(to, subject, body); err != nil {
+			logger.Logger().Error("Failed to send email", zap.String("to", to), zap.String("subject", subject), zap.Error(err))
+			return
+		}
+		logger.Logger().Debug("Sent email", zap.String("to", to), zap.String("subject", subject))
+	}()
+}
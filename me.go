@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gofrs/uuid"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// GetMe fetches the profile of the authenticated user
+func (app *App) GetMe(writer http.ResponseWriter, req *http.Request) {
+	current, ok := app.currentUser(writer, req)
+	if !ok {
+		return
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, current)
+}
+
+// selfEditableFields are the profile fields users may patch on their own account,
+// a changed email has to be verified again
+var selfEditableFields = map[string]bool{"first_name": true, "last_name": true, "email": true}
+
+// PatchMe applies a json merge patch to the profile of the authenticated user
+func (app *App) PatchMe(writer http.ResponseWriter, req *http.Request) {
+	current, ok := app.currentUser(writer, req)
+	if !ok {
+		return
+	}
+	app.patchUser(writer, req, current, selfEditableFields)
+}
+
+// ChangePassword replaces the password of the authenticated user after checking
+// the current one, every session has to log in again with the new password
+func (app *App) ChangePassword(writer http.ResponseWriter, req *http.Request) {
+	principal, ok := app.selfServicePrincipal(writer, req)
+	if !ok {
+		return
+	}
+	var request dto.ChangePasswordRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if status, errValidate := request.ValidateChangePassword(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+
+	user, errResponse := app.userRepo.GetUserByID(uuid.FromStringOrNil(principal.UserID))
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	// a stolen access token must not allow guessing the password
+	if app.loginThrottled(writer, req, user) {
+		return
+	}
+	if !app.ComparePasswords(req.Context(), user, request.CurrentPassword) {
+		app.recordLoginFailure(req.Context(), req, user)
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Current password is wrong")
+		return
+	}
+	if errResponse = app.checkPasswordPolicy(request.NewPassword, user.Email); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	if errResponse = app.userRepo.UpdatePassword(req.Context(), user.ID, request.NewPassword); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if errResponse = app.revokeAllSessions(user.ID); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("Password changed", zap.String("email", user.Email))
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, "Password changed")
+}
+
+// DeleteMe closes the account of the authenticated user, it can be restored by
+// an admin until it is purged
+func (app *App) DeleteMe(writer http.ResponseWriter, req *http.Request) {
+	principal, ok := app.selfServicePrincipal(writer, req)
+	if !ok {
+		return
+	}
+	if errResponse := app.userRepo.DeleteUser(req.Context(), principal.UserID); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if errResponse := app.revokeAllSessions(principal.UserID); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("Account closed", zap.String("email", principal.Email))
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, "Account closed")
+}
+
+// currentUser finds the authenticated user, rendering an error if there is none
+func (app *App) currentUser(writer http.ResponseWriter, req *http.Request) (*dto.UserResponse, bool) {
+	principal, ok := auth.PrincipalFromContext(req.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return nil, false
+	}
+	id, err := uuid.FromString(principal.UserID)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusForbidden, err, "Bad token")
+		return nil, false
+	}
+	current, errResponse := app.userRepo.FindUserByID(req.Context(), id)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return nil, false
+	}
+	return current, true
+}
+
+// selfServicePrincipal returns the authenticated user of account changes only
+// the user may make, admins impersonating the user are rejected
+func (app *App) selfServicePrincipal(writer http.ResponseWriter, req *http.Request) (*auth.Principal, bool) {
+	principal, ok := auth.PrincipalFromContext(req.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return nil, false
+	}
+	if principal.Actor != nil {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Not allowed while impersonating")
+		return nil, false
+	}
+	return principal, true
+}
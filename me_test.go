@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/store"
+)
+
+// profileFixture is an app with a single user whose profile is kept in memory
+type profileFixture struct {
+	app      *App
+	mail     *recordingSender
+	userID   string
+	profile  dto.UserResponse
+	verified bool
+}
+
+func newProfileFixture(t *testing.T) *profileFixture {
+	t.Helper()
+	f := &profileFixture{
+		mail:     newRecordingSender(),
+		userID:   "6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f",
+		verified: true,
+	}
+	f.profile = dto.UserResponse{ID: f.userID, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Role: dto.RoleUser}
+	conf := &Config{AppName: "test", AuthConfig: defaultAuthConfig()}
+	db := newFakeDB(f.handle)
+	f.app = &App{
+		conf:          conf,
+		userRepo:      &store.UserRepo{DB: db},
+		userTokenRepo: &store.UserTokenRepo{DB: db},
+		mailSender:    f.mail,
+	}
+	return f
+}
+
+// handle answers the statements of reading and updating the profile
+func (f *profileFixture) handle(query string, args []driver.Value) (*fakeResult, error) {
+	p := &f.profile
+	switch {
+	case strings.HasPrefix(query, "SELECT id,first_name,last_name,email,totp_confirmed,role FROM users WHERE id="):
+		if args[0] == f.userID {
+			return &fakeResult{rows: [][]driver.Value{{p.ID, p.FirstName, p.LastName, p.Email, p.IsUsing2FA, p.Role}}}, nil
+		}
+	case strings.HasPrefix(query, "UPDATE users SET first_name="):
+		if args[4] == f.userID {
+			if args[2] != p.Email {
+				f.verified = false
+			}
+			p.FirstName, p.LastName, p.Email = args[0].(string), args[1].(string), args[2].(string)
+			return &fakeResult{rows: [][]driver.Value{{p.ID, p.FirstName, p.LastName, p.Email, p.IsUsing2FA, p.Role}}}, nil
+		}
+	}
+	return nil, nil
+}
+
+// patchMe sends patch as the fixture user
+func (f *profileFixture) patchMe(patch string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPatch, "/me", strings.NewReader(patch))
+	request.Header.Set("Content-Type", "application/merge-patch+json")
+	request = request.WithContext(auth.WithPrincipal(request.Context(), &auth.Principal{UserID: f.userID, Email: f.profile.Email}))
+	recorder := httptest.NewRecorder()
+	f.app.PatchMe(recorder, request)
+	return recorder
+}
+
+func TestPatchMeChangesName(t *testing.T) {
+	f := newProfileFixture(t)
+	response := f.patchMe(`{"first_name":"Janet"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if f.profile.FirstName != "Janet" || f.profile.LastName != "Doe" || f.profile.Email != "jane@example.com" {
+		t.Errorf("profile = %+v", f.profile)
+	}
+	if !f.verified {
+		t.Error("unchanged email lost its verification")
+	}
+	f.mail.none(t)
+}
+
+func TestPatchMeEmailIsVerifiedAgain(t *testing.T) {
+	f := newProfileFixture(t)
+	response := f.patchMe(`{"email":"Janet@Example.org"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if f.profile.Email != "janet@example.org" || f.verified {
+		t.Errorf("email = %s verified %v, want janet@example.org unverified", f.profile.Email, f.verified)
+	}
+	if mail := f.mail.next(t); mail.to != "janet@example.org" || !strings.Contains(mail.body, "/verify-email?token=") {
+		t.Errorf("verification email to %s:\n%s", mail.to, mail.body)
+	}
+}
+
+func TestPatchMeRejectsOtherFields(t *testing.T) {
+	for _, patch := range []string{
+		`{"role":"admin"}`,
+		`{"first_name":"Janet","role":"admin"}`,
+		`{"is_2fa":false}`,
+		`{"id":"00000000-0000-0000-0000-000000000001"}`,
+		`{"password":"Correct-Horse-42"}`,
+		`["first_name"]`,
+	} {
+		f := newProfileFixture(t)
+		response := f.patchMe(patch)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", patch, response.Code, http.StatusBadRequest)
+		}
+		if f.profile.FirstName != "Jane" || f.profile.Role != dto.RoleUser {
+			t.Errorf("%s: profile changed to %+v", patch, f.profile)
+		}
+	}
+}
+
+func TestPatchMeReportsRejectedFields(t *testing.T) {
+	f := newProfileFixture(t)
+	response := f.patchMe(`{"role":"admin","first_name":"Janet","is_2fa":false}`)
+	var body struct {
+		Fields []dto.FieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Fields) != 2 || body.Fields[0].Field != "is_2fa" || body.Fields[1].Field != "role" {
+		t.Errorf("fields = %+v, want is_2fa and role", body.Fields)
+	}
+}
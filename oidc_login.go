@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/oidc"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// oidcState is kept in a signed cookie between redirecting to the provider and its callback
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.StandardClaims
+}
+
+// OIDCLogin redirects to the external provider to sign in
+func (app *App) OIDCLogin(writer http.ResponseWriter, request *http.Request) {
+	if app.oidcProvider == nil {
+		app.RenderErrorResponse(writer, http.StatusNotFound, nil, "OIDC login is not configured")
+		return
+	}
+
+	state := oidcState{StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(oidcStateTTL).Unix()}}
+	var err error
+	for _, value := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		if *value, err = oidc.RandomString(); err != nil {
+			app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to start OIDC login")
+			return
+		}
+	}
+	authURL, err := app.oidcProvider.AuthCodeURL(request.Context(), state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadGateway, err, "Failed to start OIDC login")
+		return
+	}
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, state).SignedString(app.oidcStateKey())
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to start OIDC login")
+		return
+	}
+
+	app.setOIDCStateCookie(writer, cookie, int(oidcStateTTL.Seconds()))
+	http.Redirect(writer, request, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes the provider login and issues tokens for the linked user
+func (app *App) OIDCCallback(writer http.ResponseWriter, request *http.Request) {
+	if app.oidcProvider == nil {
+		app.RenderErrorResponse(writer, http.StatusNotFound, nil, "OIDC login is not configured")
+		return
+	}
+	query := request.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "OIDC login failed: "+providerErr)
+		return
+	}
+
+	// the state cookie is single use
+	cookie, err := request.Cookie(oidcStateCookie)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusForbidden, err, "OIDC login expired")
+		return
+	}
+	app.setOIDCStateCookie(writer, "", -1)
+	state := &oidcState{}
+	_, err = jwt.ParseWithClaims(cookie.Value, state, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return app.oidcStateKey(), nil
+	})
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusForbidden, err, "OIDC login expired")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(state.State), []byte(query.Get("state"))) != 1 {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Invalid OIDC state")
+		return
+	}
+
+	claims, err := app.oidcProvider.Exchange(request.Context(), query.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusForbidden, err, "OIDC login failed")
+		return
+	}
+	user, errResponse := app.oidcUser(request, claims)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	// a provider login does not lift a lockout
+	if app.loginThrottled(writer, request, user) {
+		return
+	}
+	logger.Logger().Info("OIDC login for", zap.String("email", user.Email), zap.String("issuer", claims.Issuer))
+
+	if user.IsUsing2FA {
+		app.renderTwoFactorChallenge(writer, user)
+		return
+	}
+	app.recordLoginSuccess(request.Context(), user)
+
+	response, errResponse := app.issueTokens(request, user)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, response)
+}
+
+// oidcUser finds the user linked to the provider subject, or links the user
+// with the verified email address of the id token
+func (app *App) oidcUser(request *http.Request, claims *oidc.Claims) (*dto.User, *dto.ErrorResponse) {
+	ctx := request.Context()
+	userID, errResponse := app.identityRepo.FindIdentityUser(ctx, claims.Issuer, claims.Subject)
+	if errResponse == nil {
+		id, err := uuid.FromString(userID)
+		if err != nil {
+			return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "OIDC login failed"}
+		}
+		return app.userRepo.GetUserByID(id)
+	}
+	if errResponse.Status != http.StatusNotFound {
+		return nil, errResponse
+	}
+
+	// addresses the provider did not verify could take over accounts
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, &dto.ErrorResponse{Status: http.StatusForbidden, Error: nil, Message: "OIDC provider did not verify the email address"}
+	}
+	user, errResponse := app.userRepo.GetUser(claims.Email)
+	if errResponse != nil && errResponse.Status == http.StatusNotFound && app.conf.OIDCConfig.AutoRegister {
+		created, errCreate := app.userRepo.CreateExternalUser(ctx, claims.GivenName, claims.FamilyName, claims.Email)
+		if errCreate != nil {
+			return nil, errCreate
+		}
+		user, errResponse = app.userRepo.GetUserByID(uuid.FromStringOrNil(created.ID))
+	} else if errResponse != nil && errResponse.Status == http.StatusNotFound {
+		return nil, &dto.ErrorResponse{Status: http.StatusForbidden, Error: errResponse.Error, Message: "No account for this email address"}
+	}
+	if errResponse != nil {
+		return nil, errResponse
+	}
+
+	if errResponse = app.identityRepo.LinkIdentity(ctx, user.ID, claims.Issuer, claims.Subject, claims.Email); errResponse != nil {
+		return nil, errResponse
+	}
+	if errResponse = app.userRepo.SetEmailVerified(ctx, user.ID); errResponse != nil {
+		return nil, errResponse
+	}
+	user.EmailVerified = true
+	logger.Logger().Info("Linked OIDC identity", zap.String("email", user.Email), zap.String("issuer", claims.Issuer))
+	return user, nil
+}
+
+// setOIDCStateCookie sets or, with a negative maxAge, clears the state cookie
+func (app *App) setOIDCStateCookie(writer http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.conf.ServerConfig.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// oidcStateKey signs state cookies, it is derived from the password key so
+// cookies can never pass as access tokens
+func (app *App) oidcStateKey() []byte {
+	sum := sha256.Sum256([]byte("oidc-state:" + app.conf.PasswordKey))
+	return sum[:]
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/signing"
+	"github.com/mehmetkule/go-restapi/internal/store"
+	"github.com/mehmetkule/go-restapi/internal/throttle"
+)
+
+// oidcTestUser is a row of the users table of oidcFixture
+type oidcTestUser struct {
+	id           string
+	firstName    string
+	lastName     string
+	email        string
+	verified     bool
+	failedLogins int
+	lastFailed   time.Time
+}
+
+// oidcFixture is an app signing in through a stub provider, its users and
+// identities are kept in memory
+type oidcFixture struct {
+	app *App
+	idp *httptest.Server
+	key *rsa.PrivateKey
+	// claims of the id token the provider issues next, the nonce is set by login
+	claims     jwt.MapClaims
+	users      []*oidcTestUser
+	identities map[string]string
+}
+
+func newOIDCFixture(t *testing.T) *oidcFixture {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &oidcFixture{key: key, identities: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(map[string]string{
+			"issuer":                 f.idp.URL,
+			"authorization_endpoint": f.idp.URL + "/authorize",
+			"token_endpoint":         f.idp.URL + "/token",
+			"jwks_uri":               f.idp.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(map[string][]map[string]string{"keys": {{
+			"kty": "RSA", "kid": "idp", "use": "sig", "alg": "RS256",
+			"n": jwt.EncodeSegment(key.N.Bytes()), "e": jwt.EncodeSegment(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, f.claims)
+		token.Header["kid"] = "idp"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(writer).Encode(map[string]string{"access_token": "opaque", "id_token": idToken})
+	})
+	f.idp = httptest.NewServer(mux)
+	t.Cleanup(f.idp.Close)
+
+	f.claims = jwt.MapClaims{
+		"iss":            f.idp.URL,
+		"sub":            "idp-subject",
+		"aud":            "api",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"given_name":     "Jane",
+		"family_name":    "Doe",
+	}
+
+	conf := &Config{AppName: "test", PasswordKey: "test password key", AuthConfig: defaultAuthConfig()}
+	conf.ServerConfig.PublicURL = "https://api.example.com"
+	conf.OIDCConfig = OIDCConfig{Issuer: f.idp.URL, ClientID: "api"}
+	keySet, err := signing.NewKeySet("test", signing.NewHMACKey("test", []byte("test signing secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := conf.OIDCConfig.GetProvider(conf.ServerConfig.PublicURL, conf.PasswordKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newFakeDB(f.handle)
+	f.app = &App{
+		conf:             conf,
+		keySet:           keySet,
+		userRepo:         &store.UserRepo{DB: db},
+		identityRepo:     &store.IdentityRepo{DB: db},
+		refreshTokenRepo: &store.RefreshTokenRepo{DB: db},
+		orgRepo:          &store.OrgRepo{DB: db},
+		sessionRepo:      store.NewSessionRepo(db),
+		revocationRepo:   store.NewRevocationRepo(db, time.Minute),
+		ipThrottle:       throttle.NewTracker(conf.AuthConfig.IPThrottle.Policy()),
+		oidcProvider:     provider,
+	}
+	return f
+}
+
+// handle answers the statements of the oidc login
+func (f *oidcFixture) handle(query string, args []driver.Value) (*fakeResult, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT user_id FROM user_identities"):
+		if userID, ok := f.identities[args[1].(string)]; ok {
+			return &fakeResult{rows: [][]driver.Value{{userID}}}, nil
+		}
+	case strings.HasPrefix(query, "SELECT id,email,password,totp_confirmed"):
+		for _, user := range f.users {
+			if user.email == args[0] || user.id == args[0] {
+				var lastFailed driver.Value
+				if !user.lastFailed.IsZero() {
+					lastFailed = user.lastFailed
+				}
+				return &fakeResult{rows: [][]driver.Value{{user.id, user.email, "", false, dto.RoleUser, user.verified, int64(user.failedLogins), lastFailed}}}, nil
+			}
+		}
+	case strings.HasPrefix(query, "INSERT INTO users("):
+		user := &oidcTestUser{id: fmt.Sprintf("00000000-0000-4000-8000-%012d", len(f.users)+1),
+			firstName: args[0].(string), lastName: args[1].(string), email: args[2].(string), verified: true}
+		f.users = append(f.users, user)
+		return &fakeResult{rows: [][]driver.Value{{user.id}}}, nil
+	case strings.HasPrefix(query, "INSERT INTO user_identities"):
+		f.identities[args[2].(string)] = args[0].(string)
+		return &fakeResult{rows: [][]driver.Value{{"identity"}}}, nil
+	case strings.HasPrefix(query, "UPDATE users SET email_verified_at"):
+		for _, user := range f.users {
+			if user.id == args[1] {
+				user.verified = true
+			}
+		}
+	case strings.HasPrefix(query, "INSERT INTO refresh_tokens"):
+		return &fakeResult{rows: [][]driver.Value{{"6ba7b810-9dad-41d1-80b4-00c04fd430c8"}}}, nil
+	}
+	return nil, nil
+}
+
+// addUser adds a user with a verified email address
+func (f *oidcFixture) addUser(email string) *oidcTestUser {
+	user := &oidcTestUser{id: fmt.Sprintf("00000000-0000-4000-8000-%012d", len(f.users)+1), email: email, verified: true}
+	f.users = append(f.users, user)
+	return user
+}
+
+// login starts the login, lets change adjust the id token claims, returns from
+// the provider and returns the callback response
+func (f *oidcFixture) login(t *testing.T, change func(claims jwt.MapClaims)) *httptest.ResponseRecorder {
+	t.Helper()
+	start := httptest.NewRecorder()
+	f.app.OIDCLogin(start, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if start.Code != http.StatusFound {
+		t.Fatalf("login status = %d, body %s", start.Code, start.Body)
+	}
+	location, err := url.Parse(start.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.claims["nonce"] = location.Query().Get("nonce")
+	if change != nil {
+		change(f.claims)
+	}
+
+	callback := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?code=code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+	for _, cookie := range start.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	f.app.OIDCCallback(recorder, callback)
+	return recorder
+}
+
+// tokenSubject returns the subject of the access token of a login response
+func (f *oidcFixture) tokenSubject(t *testing.T, response *httptest.ResponseRecorder) string {
+	t.Helper()
+	var tokens dto.JWTToken
+	if err := json.Unmarshal(response.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("login response %s: %v", response.Body, err)
+	}
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(tokens.Token, claims, f.app.keySet.Keyfunc); err != nil {
+		t.Fatalf("access token: %v", err)
+	}
+	return claims.Subject
+}
+
+func TestOIDCCallbackLinksExistingUser(t *testing.T) {
+	f := newOIDCFixture(t)
+	user := f.addUser("jane@example.com")
+
+	response := f.login(t, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if subject := f.tokenSubject(t, response); subject != user.id {
+		t.Errorf("token subject = %s, want %s", subject, user.id)
+	}
+	if f.identities["idp-subject"] != user.id {
+		t.Error("identity not linked")
+	}
+
+	// the linked subject signs in even after the address changed at the provider
+	response = f.login(t, func(claims jwt.MapClaims) { claims["email"] = "jane.doe@example.com" })
+	if response.Code != http.StatusOK {
+		t.Fatalf("linked login status = %d, body %s", response.Code, response.Body)
+	}
+	if subject := f.tokenSubject(t, response); subject != user.id {
+		t.Errorf("linked login token subject = %s, want %s", subject, user.id)
+	}
+}
+
+func TestOIDCCallbackRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+	}{
+		{"nonce mismatch", func(claims jwt.MapClaims) { claims["nonce"] = "replayed nonce" }},
+		{"wrong aud", func(claims jwt.MapClaims) { claims["aud"] = "other client" }},
+	}
+	for _, test := range tests {
+		f := newOIDCFixture(t)
+		f.addUser("jane@example.com")
+		response := f.login(t, test.change)
+		if response.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want %d", test.name, response.Code, http.StatusForbidden)
+		}
+		if len(f.identities) != 0 {
+			t.Errorf("%s: identity linked", test.name)
+		}
+	}
+}
+
+func TestOIDCCallbackRejectsWrongState(t *testing.T) {
+	f := newOIDCFixture(t)
+	f.addUser("jane@example.com")
+	start := httptest.NewRecorder()
+	f.app.OIDCLogin(start, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+
+	callback := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?code=code&state=forged", nil)
+	for _, cookie := range start.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	response := httptest.NewRecorder()
+	f.app.OIDCCallback(response, callback)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", response.Code, http.StatusForbidden)
+	}
+}
+
+func TestOIDCCallbackRejectsUnverifiedEmail(t *testing.T) {
+	for _, autoRegister := range []bool{false, true} {
+		f := newOIDCFixture(t)
+		f.app.conf.OIDCConfig.AutoRegister = autoRegister
+		user := f.addUser("jane@example.com")
+
+		response := f.login(t, func(claims jwt.MapClaims) { claims["email_verified"] = false })
+		if response.Code != http.StatusForbidden || !strings.Contains(response.Body.String(), "did not verify the email address") {
+			t.Errorf("auto register %v: status = %d, body %s", autoRegister, response.Code, response.Body)
+		}
+		if len(f.identities) != 0 || len(f.users) != 1 {
+			t.Errorf("auto register %v: account of %s taken over", autoRegister, user.email)
+		}
+	}
+}
+
+func TestOIDCCallbackAutoRegister(t *testing.T) {
+	f := newOIDCFixture(t)
+	response := f.login(t, nil)
+	if response.Code != http.StatusForbidden || !strings.Contains(response.Body.String(), "No account") {
+		t.Errorf("without auto register: status = %d, body %s", response.Code, response.Body)
+	}
+	if len(f.users) != 0 {
+		t.Fatal("user created without auto register")
+	}
+
+	f.app.conf.OIDCConfig.AutoRegister = true
+	response = f.login(t, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("with auto register: status = %d, body %s", response.Code, response.Body)
+	}
+	if len(f.users) != 1 {
+		t.Fatalf("created %d users, want 1", len(f.users))
+	}
+	user := f.users[0]
+	if user.email != "jane@example.com" || user.firstName != "Jane" || user.lastName != "Doe" {
+		t.Errorf("created user %+v", user)
+	}
+	if f.identities["idp-subject"] != user.id {
+		t.Error("identity not linked to the created user")
+	}
+	if subject := f.tokenSubject(t, response); subject != user.id {
+		t.Errorf("token subject = %s, want %s", subject, user.id)
+	}
+}
+
+func TestOIDCCallbackRejectsLockedAccount(t *testing.T) {
+	f := newOIDCFixture(t)
+	user := f.addUser("jane@example.com")
+	user.failedLogins = f.app.conf.AuthConfig.AccountThrottle.LockoutThreshold
+	user.lastFailed = time.Now()
+
+	response := f.login(t, nil)
+	if response.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", response.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestOIDCProviderRefusesCommittedPasswordKey(t *testing.T) {
+	config := OIDCConfig{Issuer: "https://idp.example.com", ClientID: "api"}
+	for _, passwordKey := range []string{"", committedPasswordKey} {
+		if _, err := config.GetProvider("https://api.example.com", passwordKey); err == nil {
+			t.Errorf("password key %q accepted for signing the login state", passwordKey)
+		}
+	}
+	if provider, err := (&OIDCConfig{}).GetProvider("https://api.example.com", committedPasswordKey); provider != nil || err != nil {
+		t.Errorf("without oidc login: provider %v, err %v", provider, err)
+	}
+	if provider, err := config.GetProvider("https://api.example.com", "a secret"); provider == nil || err != nil {
+		t.Errorf("with a secret password key: provider %v, err %v", provider, err)
+	}
+}
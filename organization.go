@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// CreateOrganization creates an organization owned by the authenticated user
+func (app *App) CreateOrganization(writer http.ResponseWriter, req *http.Request) {
+	principal, ok := app.selfServicePrincipal(writer, req)
+	if !ok {
+		return
+	}
+	var request dto.OrganizationRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if status, errValidate := request.ValidateOrganization(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+
+	response, errResponse := app.orgRepo.CreateOrganization(req.Context(), request.Name, principal.UserID)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("Organization created", zap.String("id", response.ID), zap.String("email", principal.Email))
+
+	// render output
+	app.RenderJSON(writer, http.StatusCreated, response)
+}
+
+// GetOrganizations lists the organizations of the authenticated user
+func (app *App) GetOrganizations(writer http.ResponseWriter, req *http.Request) {
+	principal, ok := auth.PrincipalFromContext(req.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	organizations, errResponse := app.orgRepo.GetOrganizations(req.Context(), principal.UserID)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	for i := range organizations {
+		organizations[i].Current = organizations[i].ID == principal.TenantID
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, organizations)
+}
+
+// SwitchOrganization issues an access token of the session acting in another
+// organization of the user, refreshing the session keeps acting in it
+func (app *App) SwitchOrganization(writer http.ResponseWriter, req *http.Request) {
+	principal, membership, ok := app.orgMembership(writer, req)
+	if !ok {
+		return
+	}
+	if principal.Actor != nil {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Not allowed while impersonating")
+		return
+	}
+	if principal.SessionID == "" {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Token belongs to no session")
+		return
+	}
+	user, errResponse := app.userRepo.GetUserByID(uuid.FromStringOrNil(principal.UserID))
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if errResponse = app.sessionRepo.SetSessionTenant(req.Context(), principal.SessionID, membership.OrgID); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	token, err := app.newAccessToken(user, principal.SessionID, membership)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to create token")
+		return
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, dto.JWTToken{
+		Token:     token,
+		ExpiresIn: int64(app.conf.AuthConfig.AccessTokenTTL.Seconds()),
+	})
+}
+
+// GetMembers lists the members of an organization to its members
+func (app *App) GetMembers(writer http.ResponseWriter, req *http.Request) {
+	_, membership, ok := app.orgMembership(writer, req)
+	if !ok {
+		return
+	}
+	members, errResponse := app.orgRepo.GetMembers(req.Context(), membership.OrgID)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, members)
+}
+
+// AddMember invites a user to an organization, only owners can invite owners. The
+// invitee joins on accepting the invitation, whether the address belongs to an
+// account or not is not revealed.
+func (app *App) AddMember(writer http.ResponseWriter, req *http.Request) {
+	principal, membership, ok := app.orgMembership(writer, req, dto.OrgRoleOwner, dto.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var request dto.MemberRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if status, errValidate := request.ValidateMember(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+	if request.Role == dto.OrgRoleOwner && membership.Role != dto.OrgRoleOwner {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Insufficient permissions")
+		return
+	}
+
+	app.sendInvitation(writer, req, principal, dto.InvitationRequest{
+		Email:   dto.NormalizeEmail(request.Email),
+		Role:    dto.RoleUser,
+		OrgID:   membership.OrgID,
+		OrgRole: request.Role,
+	})
+}
+
+// SetMemberRole changes the role of a member, only owners can grant or take ownership
+func (app *App) SetMemberRole(writer http.ResponseWriter, req *http.Request) {
+	_, membership, ok := app.orgMembership(writer, req, dto.OrgRoleOwner, dto.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	var request dto.MemberRoleRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if status, errValidate := request.ValidateMemberRole(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+	member, ok := app.orgMember(writer, req, membership)
+	if !ok {
+		return
+	}
+	if (request.Role == dto.OrgRoleOwner || member.Role == dto.OrgRoleOwner) && membership.Role != dto.OrgRoleOwner {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Insufficient permissions")
+		return
+	}
+
+	if errResponse := app.orgRepo.SetMemberRole(req.Context(), member.OrgID, member.UserID, request.Role); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	// access tokens carry the old role, refreshing issues tokens with the new one
+	if errResponse := app.revocationRepo.RevokeUserTokens(member.UserID); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, "")
+}
+
+// RemoveMember removes a member from an organization, members can leave on their own
+func (app *App) RemoveMember(writer http.ResponseWriter, req *http.Request) {
+	principal, membership, ok := app.orgMembership(writer, req)
+	if !ok {
+		return
+	}
+	member, ok := app.orgMember(writer, req, membership)
+	if !ok {
+		return
+	}
+	if member.UserID != principal.UserID {
+		allowed := membership.Role == dto.OrgRoleOwner || membership.Role == dto.OrgRoleAdmin && member.Role != dto.OrgRoleOwner
+		if !allowed {
+			app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Insufficient permissions")
+			return
+		}
+	}
+
+	if errResponse := app.orgRepo.RemoveMember(req.Context(), member.OrgID, member.UserID); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	// access tokens acting in the organization must not outlive the membership
+	if errResponse := app.revocationRepo.RevokeUserTokens(member.UserID); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, "")
+}
+
+// orgMembership returns the caller and its membership of the organization of the
+// route, rendering an error unless the caller is a member having one of roles.
+// Any member is allowed without roles.
+func (app *App) orgMembership(writer http.ResponseWriter, req *http.Request, roles ...string) (*auth.Principal, *dto.Membership, bool) {
+	principal, ok := auth.PrincipalFromContext(req.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return nil, nil, false
+	}
+	id, err := uuid.FromString(mux.Vars(req)["id"])
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid id")
+		return nil, nil, false
+	}
+	// api keys only act in the organization they were created in
+	if principal.APIKeyID != "" && principal.TenantID != id.String() {
+		app.RenderErrorResponse(writer, http.StatusNotFound, dto.NotFoundError, "organization not found")
+		return nil, nil, false
+	}
+	// organizations of other tenants are reported missing
+	membership, errResponse := app.orgRepo.GetMembership(req.Context(), id.String(), principal.UserID)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return nil, nil, false
+	}
+	if len(roles) == 0 {
+		return principal, membership, true
+	}
+	for _, role := range roles {
+		if membership.Role == role {
+			return principal, membership, true
+		}
+	}
+	app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Insufficient permissions")
+	return nil, nil, false
+}
+
+// orgMember returns the membership of the user of the route in the organization of membership
+func (app *App) orgMember(writer http.ResponseWriter, req *http.Request, membership *dto.Membership) (*dto.Membership, bool) {
+	userID, err := uuid.FromString(mux.Vars(req)["user_id"])
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid id")
+		return nil, false
+	}
+	member, errResponse := app.orgRepo.GetMembership(req.Context(), membership.OrgID, userID.String())
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, "member not found")
+		return nil, false
+	}
+	return member, true
+}
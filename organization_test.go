@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/password"
+	"github.com/mehmetkule/go-restapi/internal/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testOrgID = "0b5d3f6a-8c2e-4e71-9a4b-2f6d8c1e3a57"
+
+// invitationRow is a stored invitation of orgFixture
+type invitationRow struct {
+	id       string
+	email    string
+	role     string
+	orgID    string
+	orgRole  string
+	hash     string
+	expires  time.Time
+	accepted bool
+	revoked  bool
+}
+
+// orgFixture is an app with an organization owned by owner, its users,
+// members and invitations are kept in memory
+type orgFixture struct {
+	app         *App
+	mail        *recordingSender
+	users       map[string]string
+	members     map[string]string
+	invitations []*invitationRow
+}
+
+func newOrgFixture(t *testing.T) *orgFixture {
+	t.Helper()
+	f := &orgFixture{
+		mail: newRecordingSender(),
+		users: map[string]string{
+			"6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f": "owner@example.com",
+			"7a2d3b9f-5e4c-4d6b-8f80-2b3c4d5e6f70": "jane@example.com",
+			"8b3e4c0a-6f5d-4e7c-9091-3c4d5e6f7081": "bob@example.com",
+		},
+		members: map[string]string{"6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f": dto.OrgRoleOwner},
+	}
+	conf := &Config{AppName: "test", AuthConfig: defaultAuthConfig(), PasswordPolicy: defaultPasswordPolicyConfig()}
+	conf.AuthConfig.InvitationURL = "https://app.example.com/invite"
+	policy, err := conf.PasswordPolicy.GetPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newFakeDB(f.handle)
+	f.app = &App{
+		conf:           conf,
+		userRepo:       &store.UserRepo{DB: db},
+		orgRepo:        &store.OrgRepo{DB: db},
+		invitationRepo: &store.InvitationRepo{DB: db, Hasher: password.Bcrypt{Cost: bcrypt.MinCost}},
+		passwordPolicy: policy,
+		mailSender:     f.mail,
+	}
+	return f
+}
+
+// handle answers the statements of inviting members and joining organizations
+func (f *orgFixture) handle(query string, args []driver.Value) (*fakeResult, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT role FROM organization_members"):
+		if role, ok := f.members[args[1].(string)]; ok && args[0] == testOrgID {
+			return &fakeResult{rows: [][]driver.Value{{role}}}, nil
+		}
+	case strings.HasPrefix(query, "SELECT id,email,password,totp_confirmed"):
+		if email, ok := f.users[args[0].(string)]; ok {
+			return &fakeResult{rows: [][]driver.Value{{args[0], email, "", false, dto.RoleUser, true, int64(0), nil}}}, nil
+		}
+	case strings.HasPrefix(query, "INSERT INTO organizations"):
+		return &fakeResult{rows: [][]driver.Value{{testOrgID}}}, nil
+	case strings.HasPrefix(query, "INSERT INTO users"):
+		id := "9c4f5d1b-7a6e-4f8d-a1a2-4d5e6f708192"
+		f.users[id] = args[2].(string)
+		return &fakeResult{rows: [][]driver.Value{{id}}}, nil
+	case strings.HasPrefix(query, "INSERT INTO organization_members"):
+		f.members[args[1].(string)] = args[2].(string)
+	case strings.HasPrefix(query, "UPDATE invitations SET revoked="):
+		for _, invitation := range f.invitations {
+			if invitation.email == args[1] && invitation.orgID == args[2] && !invitation.accepted {
+				invitation.revoked = true
+			}
+		}
+	case strings.HasPrefix(query, "INSERT INTO invitations"):
+		invitation := &invitationRow{id: "invitation", email: args[0].(string), role: args[1].(string), orgID: args[2].(string),
+			orgRole: args[3].(string), hash: args[4].(string), expires: args[6].(time.Time)}
+		f.invitations = append(f.invitations, invitation)
+		return &fakeResult{rows: [][]driver.Value{{invitation.id}}}, nil
+	case strings.HasPrefix(query, "SELECT id,email,role,COALESCE(org_id::text,'')"):
+		if invitation := f.validInvitation(args[0], args[1].(time.Time)); invitation != nil {
+			return &fakeResult{rows: [][]driver.Value{{invitation.id, invitation.email, invitation.role, invitation.orgID, invitation.orgRole, "", invitation.expires, time.Now()}}}, nil
+		}
+	case strings.HasPrefix(query, "SELECT id,email,role,org_id,org_role,invited_by FROM invitations"):
+		if invitation := f.validInvitation(args[0], args[1].(time.Time)); invitation != nil {
+			return &fakeResult{rows: [][]driver.Value{{invitation.id, invitation.email, invitation.role, invitation.orgID, invitation.orgRole, nil}}}, nil
+		}
+	case strings.HasPrefix(query, "SELECT i.id,o.id,o.name,o.created,i.org_role"):
+		if invitation := f.validInvitation(args[0], args[2].(time.Time)); invitation != nil && invitation.email == args[1] {
+			return &fakeResult{rows: [][]driver.Value{{invitation.id, invitation.orgID, "Acme", time.Now(), invitation.orgRole}}}, nil
+		}
+	case strings.HasPrefix(query, "UPDATE invitations SET accepted="):
+		for _, invitation := range f.invitations {
+			if invitation.id == args[2] {
+				invitation.accepted = true
+			}
+		}
+	}
+	return nil, nil
+}
+
+// validInvitation returns the pending invitation with hash not expired at now
+func (f *orgFixture) validInvitation(hash driver.Value, now time.Time) *invitationRow {
+	for _, invitation := range f.invitations {
+		if invitation.hash == hash && !invitation.accepted && !invitation.revoked && invitation.expires.After(now) {
+			return invitation
+		}
+	}
+	return nil
+}
+
+// userID returns the id of the fixture user with email
+func (f *orgFixture) userID(email string) string {
+	for id, userEmail := range f.users {
+		if userEmail == email {
+			return id
+		}
+	}
+	return ""
+}
+
+// call calls handler with body as the fixture user with email
+func (f *orgFixture) call(handler http.HandlerFunc, email string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	request = mux.SetURLVars(request, map[string]string{"id": testOrgID})
+	request = request.WithContext(auth.WithPrincipal(request.Context(), &auth.Principal{UserID: f.userID(email), Email: email}))
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder
+}
+
+var invitationLink = regexp.MustCompile(`https://app\.example\.com/invite\?token=(\S+)`)
+
+// invitationToken returns the token of the next invitation email, which must be sent to email
+func (f *orgFixture) invitationToken(t *testing.T, email string) string {
+	t.Helper()
+	mail := f.mail.next(t)
+	if mail.to != email {
+		t.Fatalf("invitation sent to %q, want %q", mail.to, email)
+	}
+	match := invitationLink.FindStringSubmatch(mail.body)
+	if match == nil {
+		t.Fatalf("no invitation link in email:\n%s", mail.body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestCreateOrganization(t *testing.T) {
+	f := newOrgFixture(t)
+	delete(f.members, f.userID("owner@example.com"))
+	response := f.call(f.app.CreateOrganization, "jane@example.com", `{"name":" Acme "}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	var organization dto.OrganizationResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &organization); err != nil {
+		t.Fatal(err)
+	}
+	if organization.ID != testOrgID || organization.Name != "Acme" || organization.Role != dto.OrgRoleOwner {
+		t.Errorf("organization = %+v", organization)
+	}
+	if role := f.members[f.userID("jane@example.com")]; role != dto.OrgRoleOwner || len(f.members) != 1 {
+		t.Errorf("members = %v, want only the creator as owner", f.members)
+	}
+}
+
+func TestAddMemberInvites(t *testing.T) {
+	f := newOrgFixture(t)
+	for _, email := range []string{"jane@example.com", "nobody@example.com"} {
+		response := f.call(f.app.AddMember, "owner@example.com", `{"email":"`+strings.ToUpper(email)+`","role":"admin"}`)
+		if response.Code != http.StatusCreated || !strings.Contains(response.Body.String(), `"org_role":"admin"`) {
+			t.Errorf("%s: status = %d, body %s", email, response.Code, response.Body)
+		}
+		f.invitationToken(t, email)
+	}
+	if len(f.members) != 1 {
+		t.Errorf("members = %v, nobody joined yet", f.members)
+	}
+	if len(f.invitations) != 2 || f.invitations[0].orgID != testOrgID || f.invitations[0].role != dto.RoleUser {
+		t.Errorf("invitations = %+v", f.invitations)
+	}
+}
+
+func TestAddMemberSameResponseForUnknownEmails(t *testing.T) {
+	f := newOrgFixture(t)
+	var responses []dto.InvitationResponse
+	for _, email := range []string{"jane@example.com", "nobody@example.com"} {
+		recorder := f.call(f.app.AddMember, "owner@example.com", `{"email":"`+email+`"}`)
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("%s: status = %d, body %s", email, recorder.Code, recorder.Body)
+		}
+		var response dto.InvitationResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		// only the address and times differ
+		response.Email, response.Created, response.Expires = "", time.Time{}, time.Time{}
+		responses = append(responses, response)
+	}
+	if responses[0] != responses[1] {
+		t.Errorf("known email answered %+v, unknown one %+v", responses[0], responses[1])
+	}
+}
+
+func TestAddMemberOwnersOnlyByOwners(t *testing.T) {
+	f := newOrgFixture(t)
+	f.members[f.userID("bob@example.com")] = dto.OrgRoleAdmin
+	response := f.call(f.app.AddMember, "bob@example.com", `{"email":"jane@example.com","role":"owner"}`)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", response.Code, http.StatusForbidden)
+	}
+	f.mail.none(t)
+}
+
+func TestJoinOrganization(t *testing.T) {
+	f := newOrgFixture(t)
+	f.call(f.app.AddMember, "owner@example.com", `{"email":"Jane@Example.com","role":"admin"}`)
+	token := f.invitationToken(t, "jane@example.com")
+
+	response := f.call(f.app.JoinOrganization, "jane@example.com", `{"token":"`+token+`"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if role := f.members[f.userID("jane@example.com")]; role != dto.OrgRoleAdmin {
+		t.Errorf("joined with role %q, want %q", role, dto.OrgRoleAdmin)
+	}
+
+	again := f.call(f.app.JoinOrganization, "jane@example.com", `{"token":"`+token+`"}`)
+	if again.Code != http.StatusBadRequest {
+		t.Errorf("reusing invitation status = %d, want %d", again.Code, http.StatusBadRequest)
+	}
+}
+
+func TestJoinOrganizationRejectsOtherAddress(t *testing.T) {
+	f := newOrgFixture(t)
+	f.call(f.app.AddMember, "owner@example.com", `{"email":"jane@example.com"}`)
+	token := f.invitationToken(t, "jane@example.com")
+
+	response := f.call(f.app.JoinOrganization, "bob@example.com", `{"token":"`+token+`"}`)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", response.Code, http.StatusForbidden)
+	}
+	if _, ok := f.members[f.userID("bob@example.com")]; ok {
+		t.Error("invitation of another address joined")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/store"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// ForgotPassword emails a single use password reset token to the user
+func (app *App) ForgotPassword(writer http.ResponseWriter, req *http.Request) {
+	var request dto.ForgotPasswordRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if request.Email == "" {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Email is wrong")
+		return
+	}
+	if app.mailThrottled(writer, req, request.Email, "Too many password resets requested") {
+		return
+	}
+
+	// the response is the same whether the account exists or not
+	const message = "If the account exists a password reset email has been sent"
+	user, errResponse := app.userRepo.GetUser(request.Email)
+	if errResponse != nil {
+		logger.Logger().Debug("Password reset for unknown email")
+		app.RenderJSON(writer, http.StatusOK, message)
+		return
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to create reset token")
+		return
+	}
+	expires := time.Now().Add(app.conf.AuthConfig.PasswordResetTTL)
+	if errResponse = app.userTokenRepo.CreateUserToken(req.Context(), user.ID, store.TokenPurposePasswordReset, hashToken(token), expires); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	app.sendMail(user.Email, "Password reset", app.passwordResetBody(token))
+	app.RenderJSON(writer, http.StatusOK, message)
+}
+
+// ResetPassword sets a new password using a reset token and logs out every session
+func (app *App) ResetPassword(writer http.ResponseWriter, req *http.Request) {
+	var request dto.ResetPasswordRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if status, errValidate := request.ValidateResetPassword(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+
+	// the token is only used up once the new password is accepted
+	userID, errResponse := app.userTokenRepo.PeekUserToken(req.Context(), store.TokenPurposePasswordReset, hashToken(request.Token))
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	id, err := uuid.FromString(userID)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to reset password")
+		return
+	}
+	user, errResponse := app.userRepo.GetUserByID(id)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if errResponse = app.checkPasswordPolicy(request.Password, user.Email); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	if userID, errResponse = app.userTokenRepo.ConsumeUserToken(req.Context(), store.TokenPurposePasswordReset, hashToken(request.Token)); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if errResponse = app.userRepo.UpdatePassword(req.Context(), userID, request.Password); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if errResponse = app.revokeAllSessions(userID); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("Password reset", zap.String("userID", userID))
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, "Password reset successful")
+}
+
+// checkPasswordPolicy rejects passwords the policy does not accept for email with reasons for the password field
+func (app *App) checkPasswordPolicy(password string, email string) *dto.ErrorResponse {
+	reasons := app.passwordPolicy.Check(password, email)
+	if len(reasons) == 0 {
+		return nil
+	}
+	validationErr := &dto.ValidationError{}
+	for _, reason := range reasons {
+		validationErr.Fields = append(validationErr.Fields, dto.FieldError{Field: "password", Reason: reason})
+	}
+	return &dto.ErrorResponse{Status: http.StatusBadRequest, Error: validationErr, Message: "Password is too weak"}
+}
+
+// passwordResetBody creates the text of the password reset email
+func (app *App) passwordResetBody(token string) string {
+	validity := app.conf.AuthConfig.PasswordResetTTL.String()
+	if resetURL := app.conf.AuthConfig.PasswordResetURL; resetURL != "" {
+		link := resetURL + "?token=" + url.QueryEscape(token)
+		return fmt.Sprintf("A password reset was requested for your account.\n\nOpen the link below to choose a new password, it is valid for %s:\n%s\n\nIf you did not request it you can ignore this email.\n", validity, link)
+	}
+	return fmt.Sprintf("A password reset was requested for your account.\n\nUse the token below to choose a new password, it is valid for %s:\n%s\n\nIf you did not request it you can ignore this email.\n", validity, token)
+}
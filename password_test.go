@@ -0,0 +1,297 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/password"
+	"github.com/mehmetkule/go-restapi/internal/store"
+	"github.com/mehmetkule/go-restapi/internal/throttle"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sentMail is an email captured by recordingSender
+type sentMail struct {
+	to      string
+	subject string
+	body    string
+}
+
+// recordingSender captures emails instead of sending them
+type recordingSender struct {
+	sent chan sentMail
+}
+
+func newRecordingSender() *recordingSender {
+	return &recordingSender{sent: make(chan sentMail, 10)}
+}
+
+func (s *recordingSender) Send(to string, subject string, body string) error {
+	s.sent <- sentMail{to: to, subject: subject, body: body}
+	return nil
+}
+
+// next waits for the next email, emails are sent in the background
+func (s *recordingSender) next(t *testing.T) sentMail {
+	t.Helper()
+	select {
+	case mail := <-s.sent:
+		return mail
+	case <-time.After(2 * time.Second):
+		t.Fatal("no email sent")
+		return sentMail{}
+	}
+}
+
+// none fails if an email is sent shortly after
+func (s *recordingSender) none(t *testing.T) {
+	t.Helper()
+	select {
+	case mail := <-s.sent:
+		t.Fatalf("unexpected email to %s", mail.to)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// resetToken is a stored password reset token of passwordFixture
+type resetToken struct {
+	userID  string
+	purpose string
+	hash    string
+	expires time.Time
+	used    bool
+}
+
+// passwordFixture is an app with a single user whose users and user_tokens
+// tables are kept in memory
+type passwordFixture struct {
+	app          *App
+	mail         *recordingSender
+	userID       string
+	email        string
+	passwordHash string
+	tokens       []*resetToken
+	// keysRevoked is set once the api keys of the user were revoked
+	keysRevoked bool
+}
+
+func newPasswordFixture(t *testing.T) *passwordFixture {
+	t.Helper()
+	hasher := password.Bcrypt{Cost: bcrypt.MinCost}
+	hash, err := hasher.Hash("Old-Password-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &passwordFixture{
+		mail:         newRecordingSender(),
+		userID:       "6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f",
+		email:        "jane@example.com",
+		passwordHash: hash,
+	}
+
+	conf := &Config{AppName: "test", AuthConfig: defaultAuthConfig(), PasswordPolicy: defaultPasswordPolicyConfig()}
+	conf.AuthConfig.PasswordResetURL = "https://app.example.com/reset"
+	policy, err := conf.PasswordPolicy.GetPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newFakeDB(f.handle)
+	f.app = &App{
+		conf:             conf,
+		userRepo:         &store.UserRepo{DB: db, Hasher: hasher},
+		userTokenRepo:    &store.UserTokenRepo{DB: db},
+		refreshTokenRepo: &store.RefreshTokenRepo{DB: db},
+		apiKeyRepo:       &store.APIKeyRepo{DB: db},
+		revocationRepo:   store.NewRevocationRepo(db, time.Minute),
+		ipThrottle:       throttle.NewTracker(conf.AuthConfig.IPThrottle.Policy()),
+		magicThrottle:    throttle.NewTracker(conf.AuthConfig.MagicLinkThrottle.Policy()),
+		passwordPolicy:   policy,
+		mailSender:       f.mail,
+	}
+	return f
+}
+
+// handle answers the statements of the password reset flow
+func (f *passwordFixture) handle(query string, args []driver.Value) (*fakeResult, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT id,email,password,totp_confirmed"):
+		if args[0] != f.email && args[0] != f.userID {
+			return nil, nil
+		}
+		return &fakeResult{rows: [][]driver.Value{{f.userID, f.email, f.passwordHash, false, "user", true, int64(0), nil}}}, nil
+	case strings.HasPrefix(query, "UPDATE user_tokens SET used=$1 WHERE user_id="):
+		for _, token := range f.tokens {
+			if token.userID == args[1] && token.purpose == args[2] {
+				token.used = true
+			}
+		}
+	case strings.HasPrefix(query, "INSERT INTO user_tokens"):
+		f.tokens = append(f.tokens, &resetToken{userID: args[0].(string), purpose: args[1].(string), hash: args[2].(string), expires: args[3].(time.Time)})
+	case strings.HasPrefix(query, "SELECT user_id FROM user_tokens"):
+		if token := f.validToken(args[0], args[1], args[2].(time.Time)); token != nil {
+			return &fakeResult{rows: [][]driver.Value{{token.userID}}}, nil
+		}
+	case strings.HasPrefix(query, "UPDATE user_tokens SET used=$1 WHERE token_hash="):
+		if token := f.validToken(args[1], args[2], args[0].(time.Time)); token != nil {
+			token.used = true
+			return &fakeResult{rows: [][]driver.Value{{token.userID}}}, nil
+		}
+	case strings.HasPrefix(query, "UPDATE api_keys SET revoked="):
+		f.keysRevoked = f.keysRevoked || args[1] == f.userID
+	case strings.HasPrefix(query, "UPDATE users SET password="):
+		if args[1] == f.userID {
+			f.passwordHash = args[0].(string)
+			return &fakeResult{affected: 1}, nil
+		}
+	}
+	return nil, nil
+}
+
+// validToken returns the unused token with hash for purpose not expired at now
+func (f *passwordFixture) validToken(hash driver.Value, purpose driver.Value, now time.Time) *resetToken {
+	for _, token := range f.tokens {
+		if token.hash == hash && token.purpose == purpose && !token.used && token.expires.After(now) {
+			return token
+		}
+	}
+	return nil
+}
+
+// post calls handler with body and returns the recorded response
+func post(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	return recorder
+}
+
+var resetLink = regexp.MustCompile(`https://app\.example\.com/reset\?token=(\S+)`)
+
+// requestReset requests a password reset for the fixture user and returns the emailed token
+func (f *passwordFixture) requestReset(t *testing.T) string {
+	t.Helper()
+	if response := post(f.app.ForgotPassword, `{"email":"Jane@Example.com"}`); response.Code != http.StatusOK {
+		t.Fatalf("forgot password status = %d, body %s", response.Code, response.Body)
+	}
+	mail := f.mail.next(t)
+	if mail.to != f.email {
+		t.Fatalf("email sent to %q, want %q", mail.to, f.email)
+	}
+	match := resetLink.FindStringSubmatch(mail.body)
+	if match == nil {
+		t.Fatalf("no reset link in email:\n%s", mail.body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestForgotPasswordSendsToken(t *testing.T) {
+	f := newPasswordFixture(t)
+	token := f.requestReset(t)
+
+	if len(f.tokens) != 1 {
+		t.Fatalf("stored %d tokens, want 1", len(f.tokens))
+	}
+	stored := f.tokens[0]
+	if stored.hash != hashToken(token) || stored.purpose != store.TokenPurposePasswordReset || stored.userID != f.userID {
+		t.Errorf("stored token %+v does not belong to the emailed one", stored)
+	}
+	if stored.hash == token {
+		t.Error("token stored in plain text")
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	f := newPasswordFixture(t)
+	known := post(f.app.ForgotPassword, `{"email":"jane@example.com"}`)
+	f.mail.next(t)
+
+	unknown := post(f.app.ForgotPassword, `{"email":"nobody@example.com"}`)
+	if unknown.Code != known.Code || unknown.Body.String() != known.Body.String() {
+		t.Errorf("unknown email answered %d %s, known one %d %s", unknown.Code, unknown.Body, known.Code, known.Body)
+	}
+	f.mail.none(t)
+}
+
+func TestResetPasswordTokenWorksOnce(t *testing.T) {
+	f := newPasswordFixture(t)
+	token := f.requestReset(t)
+
+	body := `{"token":"` + token + `","password":"Correct-Horse-42"}`
+	if response := post(f.app.ResetPassword, body); response.Code != http.StatusOK {
+		t.Fatalf("reset status = %d, body %s", response.Code, response.Body)
+	}
+	if ok, _ := f.app.userRepo.Hasher.Verify(f.passwordHash, "Correct-Horse-42"); !ok {
+		t.Error("password not changed")
+	}
+	if !f.keysRevoked {
+		t.Error("api keys survived the reset")
+	}
+
+	again := post(f.app.ResetPassword, `{"token":"`+token+`","password":"Another-Horse-43"}`)
+	if again.Code != http.StatusBadRequest {
+		t.Errorf("reusing token status = %d, want %d", again.Code, http.StatusBadRequest)
+	}
+	if ok, _ := f.app.userRepo.Hasher.Verify(f.passwordHash, "Correct-Horse-42"); !ok {
+		t.Error("reused token changed the password")
+	}
+}
+
+func TestResetPasswordTokenExpires(t *testing.T) {
+	f := newPasswordFixture(t)
+	token := f.requestReset(t)
+	f.tokens[0].expires = time.Now().Add(-time.Second)
+
+	response := post(f.app.ResetPassword, `{"token":"`+token+`","password":"Correct-Horse-42"}`)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("expired token status = %d, want %d", response.Code, http.StatusBadRequest)
+	}
+}
+
+func TestResetPasswordOlderTokenInvalidated(t *testing.T) {
+	f := newPasswordFixture(t)
+	first := f.requestReset(t)
+	f.requestReset(t)
+
+	response := post(f.app.ResetPassword, `{"token":"`+first+`","password":"Correct-Horse-42"}`)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("older token status = %d, want %d", response.Code, http.StatusBadRequest)
+	}
+}
+
+func TestForgotPasswordThrottled(t *testing.T) {
+	f := newPasswordFixture(t)
+	for _, email := range []string{"jane@example.com", "nobody@example.com"} {
+		// free attempts pass, so does the one after them which starts the backoff
+		var response *httptest.ResponseRecorder
+		for i := 0; i <= f.app.conf.AuthConfig.MagicLinkThrottle.FreeAttempts+1; i++ {
+			response = post(f.app.ForgotPassword, `{"email":"`+email+`"}`)
+		}
+		if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: status = %d, want %d with Retry-After", email, response.Code, http.StatusTooManyRequests)
+		}
+	}
+}
+
+func TestChangePasswordRevokesAPIKeys(t *testing.T) {
+	f := newPasswordFixture(t)
+	request := httptest.NewRequest(http.MethodPost, "/rap/me/password", strings.NewReader(`{"current_password":"Old-Password-1","new_password":"Correct-Horse-42"}`))
+	request = request.WithContext(auth.WithPrincipal(request.Context(), &auth.Principal{UserID: f.userID, Email: f.email}))
+	response := httptest.NewRecorder()
+	f.app.ChangePassword(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", response.Code, response.Body)
+	}
+	if !f.keysRevoked {
+		t.Error("api keys survived the password change")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// ExportUserData streams a zip archive of the users row and every document of
+// a user, answering a data subject access request
+func (app *App) ExportUserData(writer http.ResponseWriter, req *http.Request) {
+	id, err := uuid.FromString(mux.Vars(req)["id"])
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid id")
+		return
+	}
+	user, errResponse := app.privacyRepo.ExportUser(req.Context(), id.String())
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	request, errResponse := app.privacyRepo.CreatePrivacyRequest(req.Context(), user.ID, dto.PrivacyRequestExport, dto.PrivacyRequestRunning)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	// the status is sent with the first byte of the archive, failures later on are only recorded
+	writer.Header().Set("Content-Type", "application/zip")
+	writer.Header().Set("Content-Disposition", `attachment; filename="user-`+user.ID+`.zip"`)
+	err = app.writeUserExport(req.Context(), zip.NewWriter(writer), user)
+	if err != nil {
+		logger.Logger().Error("Failed to export user data", zap.String("id", user.ID), zap.Error(err))
+	}
+	if errComplete := app.privacyRepo.CompletePrivacyRequest(context.Background(), request.ID, err); errComplete != nil {
+		logger.Logger().Error("Failed to record export", zap.String("id", user.ID), zap.Error(errComplete))
+	}
+}
+
+// writeUserExport writes user.json, the data of each document and documents.json listing them to archive
+func (app *App) writeUserExport(ctx context.Context, archive *zip.Writer, user *dto.UserExport) error {
+	if err := writeJSONEntry(archive, "user.json", user); err != nil {
+		return err
+	}
+	documents := []dto.DocumentExport{}
+	err := app.privacyRepo.ExportDocuments(ctx, user.ID, func(document dto.DocumentExport, data []byte) error {
+		// names are chosen by uploaders and must not escape the documents folder
+		document.File = "documents/" + document.ID
+		if name := path.Base(strings.ReplaceAll(document.Name, `\`, "/")); name != "." && name != "/" {
+			document.File += "-" + name
+		}
+		entry, err := archive.Create(document.File)
+		if err != nil {
+			return err
+		}
+		if _, err = entry.Write(data); err != nil {
+			return err
+		}
+		documents = append(documents, document)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = writeJSONEntry(archive, "documents.json", documents); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeJSONEntry adds a file named name holding value as indented json to archive
+func writeJSONEntry(archive *zip.Writer, name string, value interface{}) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// EraseUserData records a request to erase the data of a user, it is processed in the background
+func (app *App) EraseUserData(writer http.ResponseWriter, req *http.Request) {
+	id, err := uuid.FromString(mux.Vars(req)["id"])
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid id")
+		return
+	}
+	// deleted users which were not purged yet can be erased too
+	user, errResponse := app.privacyRepo.ExportUser(req.Context(), id.String())
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	request, errResponse := app.privacyRepo.CreatePrivacyRequest(req.Context(), user.ID, dto.PrivacyRequestErasure, dto.PrivacyRequestPending)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Warn("Erasure requested", zap.String("id", user.ID), zap.String("request", request.ID))
+
+	select {
+	case app.erasureWake <- struct{}{}:
+	default:
+		// processing was woken already
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusAccepted, request)
+}
+
+// GetPrivacyRequests lists the export and erasure requests of a user
+func (app *App) GetPrivacyRequests(writer http.ResponseWriter, req *http.Request) {
+	id, err := uuid.FromString(mux.Vars(req)["id"])
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid id")
+		return
+	}
+	requests, errResponse := app.privacyRepo.GetPrivacyRequests(req.Context(), id.String())
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, requests)
+}
+
+// processErasures erases the users of pending erasure requests when woken and
+// every purge interval until stop is closed
+func (app *App) processErasures(stop <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval := app.conf.Retention.PurgeInterval; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick:
+		case <-app.erasureWake:
+		}
+		app.eraseUsers()
+	}
+}
+
+// eraseUsers processes pending erasure requests until none is left
+func (app *App) eraseUsers() {
+	ctx := context.Background()
+	for {
+		id, userID, ok, err := app.privacyRepo.ClaimErasureRequest(ctx)
+		if err != nil {
+			// retried on the next tick
+			logger.Logger().Error("Failed to claim erasure request", zap.Error(err))
+			return
+		}
+		if !ok {
+			return
+		}
+
+		err = app.privacyRepo.EraseUser(ctx, userID)
+		if err == nil {
+			// access tokens of the user stay valid until revoked
+			if errResponse := app.revokeAllSessions(userID); errResponse != nil {
+				err = errResponse.Error
+			}
+		}
+		if err != nil {
+			logger.Logger().Error("Failed to erase user", zap.String("id", userID), zap.Error(err))
+		} else {
+			logger.Logger().Warn("Erased user", zap.String("id", userID), zap.String("request", id))
+		}
+		if err = app.privacyRepo.CompletePrivacyRequest(ctx, id, err); err != nil {
+			logger.Logger().Error("Failed to record erasure", zap.String("id", userID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/store"
+)
+
+// statement is a statement run against a fakeDB
+type statement struct {
+	query string
+	args  []driver.Value
+}
+
+// recordingPrivacyRepo returns a repo of a database holding the single user
+// userID and the statements run against it
+func recordingPrivacyRepo(userID string) (*store.PrivacyRepo, *[]statement) {
+	var statements []statement
+	repo := &store.PrivacyRepo{DB: newFakeDB(func(query string, args []driver.Value) (*fakeResult, error) {
+		statements = append(statements, statement{query: strings.Join(strings.Fields(query), " "), args: args})
+		if strings.HasPrefix(query, "SELECT id FROM users") && args[0] == userID {
+			return &fakeResult{rows: [][]driver.Value{{userID}}}, nil
+		}
+		return &fakeResult{affected: 1}, nil
+	})}
+	return repo, &statements
+}
+
+// recordErasure erases userID and returns the statements run for it
+func recordErasure(t *testing.T, ctx context.Context, userID string) []statement {
+	t.Helper()
+	repo, statements := recordingPrivacyRepo(userID)
+	if err := repo.EraseUser(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	return *statements
+}
+
+// findStatement returns the first statement starting with prefix
+func findStatement(t *testing.T, statements []statement, prefix string) statement {
+	t.Helper()
+	for _, statement := range statements {
+		if strings.HasPrefix(statement.query, prefix) {
+			return statement
+		}
+	}
+	t.Fatalf("no statement %q", prefix)
+	return statement{}
+}
+
+func TestEraseUserForgetsImpersonationAddresses(t *testing.T) {
+	userID := "6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f"
+	update := findStatement(t, recordErasure(t, context.Background(), userID), "UPDATE impersonation_log SET ip=NULL")
+	if !strings.Contains(update.query, "user_id=$1") || !strings.Contains(update.query, "actor_id=$1") || update.args[0] != userID {
+		t.Errorf("impersonation log update %q %v does not cover the user as actor and target", update.query, update.args)
+	}
+}
+
+func TestEraseUserRemovesMemberships(t *testing.T) {
+	userID := "6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f"
+	remove := findStatement(t, recordErasure(t, context.Background(), userID), "DELETE FROM organization_members")
+	if !strings.Contains(remove.query, "user_id=$1") || remove.args[0] != userID {
+		t.Errorf("membership removal %q %v does not cover the user", remove.query, remove.args)
+	}
+}
+
+func TestPrivacyRequestsStayInTenant(t *testing.T) {
+	userID := "6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f"
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{UserID: "admin", TenantID: "org"})
+	repo, statements := recordingPrivacyRepo(userID)
+	repo.ExportUser(ctx, userID)
+	repo.ExportDocuments(ctx, userID, func(dto.DocumentExport, []byte) error { return nil })
+	if err := repo.EraseUser(ctx, "other"); err != dto.NotFoundError {
+		t.Errorf("erasing a user outside of the tenant: err = %v", err)
+	}
+
+	for _, prefix := range []string{"SELECT id,first_name", "SELECT id,parent_id", "SELECT id FROM users"} {
+		query := findStatement(t, *statements, prefix)
+		if !strings.Contains(query.query, "organization_members") || query.args[1] != "org" {
+			t.Errorf("%q %v is not limited to the tenant", query.query, query.args)
+		}
+	}
+	for _, statement := range *statements {
+		if strings.HasPrefix(statement.query, "DELETE") || strings.HasPrefix(statement.query, "UPDATE") {
+			t.Errorf("%q run for a user outside of the tenant", statement.query)
+		}
+	}
+}
+
+func TestEraseUserAnonymizesInvitations(t *testing.T) {
+	userID := "6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f"
+	statements := recordErasure(t, context.Background(), userID)
+	for _, statement := range statements {
+		if strings.HasPrefix(statement.query, "UPDATE users") {
+			t.Fatal("users row anonymized before the invitations sent to its address")
+		}
+		if strings.HasPrefix(statement.query, "UPDATE invitations") {
+			if !strings.Contains(statement.query, "email='erased-'") || !strings.Contains(statement.query, "revoked=") ||
+				!strings.Contains(statement.query, "user_id=$1") || !strings.Contains(statement.query, "lower(email)=(SELECT lower(email) FROM users WHERE id=$1)") {
+				t.Errorf("invitations update %q does not anonymize the invitations of the user", statement.query)
+			}
+			return
+		}
+	}
+	t.Error("invitations not anonymized")
+}
+
+func TestEraseUserDataQueuesErasure(t *testing.T) {
+	userID := "6f1c2a8e-4d3b-4c5a-9e7f-1a2b3c4d5e6f"
+	var statements []string
+	db := newFakeDB(func(query string, args []driver.Value) (*fakeResult, error) {
+		statements = append(statements, query)
+		switch {
+		case strings.HasPrefix(query, "SELECT id,first_name") && args[0] == userID:
+			return &fakeResult{rows: [][]driver.Value{{userID, "Jane", "Doe", "jane@example.com", nil, false, dto.RoleUser, int64(0), nil, nil, nil, nil, nil}}}, nil
+		case strings.HasPrefix(query, "INSERT INTO privacy_requests"):
+			return &fakeResult{rows: [][]driver.Value{{"request"}}}, nil
+		}
+		return nil, nil
+	})
+	app := &App{privacyRepo: &store.PrivacyRepo{DB: db}, erasureWake: make(chan struct{}, 1)}
+
+	request := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{"id": userID})
+	recorder := httptest.NewRecorder()
+	app.EraseUserData(recorder, request)
+
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d, body %s", recorder.Code, http.StatusAccepted, recorder.Body)
+	}
+	var response dto.PrivacyRequestResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.ID != "request" || response.Kind != dto.PrivacyRequestErasure || response.Status != dto.PrivacyRequestPending {
+		t.Errorf("request = %+v, want a pending erasure", response)
+	}
+	select {
+	case <-app.erasureWake:
+	default:
+		t.Error("erasure processing not woken")
+	}
+	for _, query := range statements {
+		if strings.HasPrefix(query, "DELETE") || strings.HasPrefix(query, "UPDATE") {
+			t.Errorf("%q run before the erasure was processed", query)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// purgeDeletedUsers removes users deleted longer than the retention ago every
+// purge interval until stop is closed
+func (app *App) purgeDeletedUsers(stop <-chan struct{}) {
+	retention := app.conf.Retention
+	if retention.DeletedUsers <= 0 || retention.PurgeInterval <= 0 {
+		logger.Logger().Info("Purging deleted users is disabled")
+		return
+	}
+	ticker := time.NewTicker(retention.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			purged, err := app.userRepo.PurgeDeletedUsers(context.Background(), time.Now().Add(-retention.DeletedUsers))
+			if err != nil {
+				// retried on the next tick
+				logger.Logger().Error("Failed to purge deleted users", zap.Error(err))
+				continue
+			}
+			if purged > 0 {
+				logger.Logger().Info("Purged deleted users", zap.Int("users", purged))
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+)
+
+// RequireRole allows only callers having one of roles, it must run after JWTHandler
+func (app *App) RequireRole(roles ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			principal, ok := auth.PrincipalFromContext(request.Context())
+			if !ok || !principal.HasAnyRole(roles...) {
+				app.RenderErrorResponse(response, http.StatusForbidden, nil, "Insufficient permissions")
+				return
+			}
+			next.ServeHTTP(response, request)
+		})
+	}
+}
+
+// RequireRoleOrOwner allows callers having one of roles or whose user id is the route variable param
+func (app *App) RequireRoleOrOwner(param string, roles ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			principal, ok := auth.PrincipalFromContext(request.Context())
+			if !ok {
+				app.RenderErrorResponse(response, http.StatusForbidden, nil, "Insufficient permissions")
+				return
+			}
+			if owner := mux.Vars(request)[param]; owner == "" || owner != principal.UserID {
+				if !principal.HasAnyRole(roles...) {
+					app.RenderErrorResponse(response, http.StatusForbidden, nil, "Insufficient permissions")
+					return
+				}
+			}
+			next.ServeHTTP(response, request)
+		})
+	}
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
 	"github.com/mehmetkule/go-restapi/logger"
 	"go.uber.org/zap"
 	"net/http"
@@ -14,25 +15,74 @@ func (app *App) AddRoutes() {
 
 	//Health Check Status
 	app.AddRoute("GET", "/health", app.HealthCheck)
+	app.AddRoute("GET", "/.well-known/jwks.json", app.JWKS)
 
 	// User
 	app.AddRoute("POST", "/login", app.Login)
+	app.AddRoute("POST", "/token/refresh", app.RefreshToken)
+	app.AddRouteWithMiddleware("POST", "/logout", app.Logout, app.JWTHandler)
+	app.AddRouteWithMiddleware("POST", "/rap/users/{id}/sessions/revoke-all", app.RevokeAllSessions, app.JWTHandler, app.RequireRoleOrOwner("id", dto.RoleAdmin))
+	app.AddRoute("POST", "/login/2fa", app.LoginTwoFactor)
+	app.AddRoute("POST", "/login/magic", app.RequestMagicLink)
+	app.AddRoute("GET", "/login/magic/callback", app.LoginMagicLink)
+	app.AddRoute("POST", "/login/magic/callback", app.LoginMagicLink)
+	app.AddRoute("GET", "/auth/oidc/login", app.OIDCLogin)
+	app.AddRoute("GET", "/auth/oidc/callback", app.OIDCCallback)
+	app.AddRouteWithMiddleware("POST", "/rap/2fa/enroll", app.EnrollTwoFactor, app.JWTHandler)
+	app.AddRouteWithMiddleware("POST", "/rap/2fa/confirm", app.ConfirmTwoFactor, app.JWTHandler)
+	app.AddRouteWithMiddleware("POST", "/rap/2fa/disable", app.DisableTwoFactor, app.JWTHandler)
 	app.AddRoute("POST", "/register", app.Register)
-	app.AddRouteWithMiddleware("GET", "/rap/users", app.GetUsers,app.JWTHandler)
-	app.AddRouteWithMiddleware("GET", "/rap/{id}", app.FindUserByID, app.JWTHandler)
-	app.AddRouteWithMiddleware("GET", "/rap/email/{email}", app.FindUserByEmail, app.JWTHandler)
-	app.AddRouteWithMiddleware("DELETE", "/rap/user/{id}", app.DeleteUser, app.JWTHandler)
+	app.AddRoute("POST", "/password/forgot", app.ForgotPassword)
+	app.AddRoute("POST", "/password/reset", app.ResetPassword)
+	app.AddRoute("POST", "/invitations/accept", app.AcceptInvitation)
+	app.AddRoute("GET", "/verify-email", app.VerifyEmail)
+	app.AddRoute("POST", "/verify-email/resend", app.ResendVerification)
+	app.AddRouteWithMiddleware("GET", "/rap/me", app.GetMe, app.JWTHandler)
+	app.AddRouteWithMiddleware("PATCH", "/rap/me", app.PatchMe, app.JWTHandler)
+	app.AddRouteWithMiddleware("DELETE", "/rap/me", app.DeleteMe, app.JWTHandler)
+	app.AddRouteWithMiddleware("POST", "/rap/me/password", app.ChangePassword, app.JWTHandler)
+	app.AddRouteWithMiddleware("GET", "/rap/me/sessions", app.GetSessions, app.JWTHandler)
+	app.AddRouteWithMiddleware("DELETE", "/rap/me/sessions/{id}", app.RevokeSession, app.JWTHandler)
+	app.AddRouteWithMiddleware("POST", "/rap/api-keys", app.CreateAPIKey, app.JWTHandler)
+	app.AddRouteWithMiddleware("GET", "/rap/api-keys", app.GetAPIKeys, app.JWTHandler)
+	app.AddRouteWithMiddleware("DELETE", "/rap/api-keys/{id}", app.RevokeAPIKey, app.JWTHandler)
+	app.AddRouteWithMiddleware("GET", "/rap/users", app.GetUsers,app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRole(dto.RoleAdmin))
+	app.AddRouteWithMiddleware("POST", "/rap/users/import", app.ImportUsers, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRole(dto.RoleAdmin))
+	app.AddRouteWithMiddleware("GET", "/rap/users/export", app.ExportUsers, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRole(dto.RoleAdmin))
+	app.AddRouteWithMiddleware("GET", "/rap/{id}", app.FindUserByID, app.APIKeyHandler(dto.ScopeUsersRead, dto.ScopeUsersAdmin), app.RequireRoleOrOwner("id", dto.RoleAdmin))
+	app.AddRouteWithMiddleware("GET", "/rap/email/{email}", app.FindUserByEmail, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRole(dto.RoleAdmin))
+	app.AddRouteWithMiddleware("DELETE", "/rap/user/{id}", app.DeleteUser, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRoleOrOwner("id", dto.RoleAdmin))
+	app.AddRouteWithMiddleware("PUT", "/rap/user/{id}", app.UpdateUser, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRoleOrOwner("id", dto.RoleAdmin))
+	app.AddRouteWithMiddleware("PATCH", "/rap/user/{id}", app.PatchUser, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRoleOrOwner("id", dto.RoleAdmin))
+	app.AddRouteWithMiddleware("POST", "/rap/user/{id}/restore", app.RestoreUser, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRole(dto.RoleAdmin))
+	app.AddRouteWithMiddleware("PUT", "/rap/user/{id}/role", app.SetUserRole, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRole(dto.RoleAdmin))
+	app.AddRouteWithMiddleware("POST", "/rap/users/{id}/unlock", app.UnlockUser, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRole(dto.RoleAdmin))
+	app.AddRouteWithMiddleware("GET", "/rap/users/{id}/export", app.ExportUserData, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRoleOrOwner("id", dto.RoleAdmin))
+	app.AddRouteWithMiddleware("POST", "/rap/users/{id}/erase", app.EraseUserData, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRole(dto.RoleAdmin))
+	app.AddRouteWithMiddleware("GET", "/rap/users/{id}/privacy-requests", app.GetPrivacyRequests, app.APIKeyHandler(dto.ScopeUsersAdmin), app.RequireRole(dto.RoleAdmin))
+	app.AddRouteWithMiddleware("POST", "/rap/users/{id}/impersonate", app.Impersonate, app.JWTHandler, app.RequireRole(dto.RoleAdmin))
+	app.AddRouteWithMiddleware("POST", "/rap/invitations", app.CreateInvitation, app.APIKeyHandler(dto.ScopeUsersAdmin))
+	app.AddRouteWithMiddleware("GET", "/rap/invitations", app.GetInvitations, app.APIKeyHandler(dto.ScopeUsersAdmin))
+	app.AddRouteWithMiddleware("DELETE", "/rap/invitations/{id}", app.RevokeInvitation, app.APIKeyHandler(dto.ScopeUsersAdmin))
+	app.AddRouteWithMiddleware("POST", "/rap/invitations/join", app.JoinOrganization, app.JWTHandler)
+	app.AddRouteWithMiddleware("POST", "/rap/orgs", app.CreateOrganization, app.JWTHandler)
+	app.AddRouteWithMiddleware("GET", "/rap/orgs", app.GetOrganizations, app.JWTHandler)
+	app.AddRouteWithMiddleware("POST", "/rap/orgs/{id}/switch", app.SwitchOrganization, app.JWTHandler)
+	app.AddRouteWithMiddleware("GET", "/rap/orgs/{id}/members", app.GetMembers, app.APIKeyHandler(dto.ScopeUsersRead, dto.ScopeUsersAdmin))
+	app.AddRouteWithMiddleware("POST", "/rap/orgs/{id}/members", app.AddMember, app.APIKeyHandler(dto.ScopeUsersAdmin))
+	app.AddRouteWithMiddleware("PUT", "/rap/orgs/{id}/members/{user_id}", app.SetMemberRole, app.APIKeyHandler(dto.ScopeUsersAdmin))
+	app.AddRouteWithMiddleware("DELETE", "/rap/orgs/{id}/members/{user_id}", app.RemoveMember, app.APIKeyHandler(dto.ScopeUsersAdmin))
 	//Relation API
 
 	//Health Check Status
 	app.AddRoute("GET", "/health", app.HealthCheck)
 
 	//File Upload API
-	app.AddRoute("POST", "/rap/file/{parent_id}", app.AddFile)
-	app.AddRouteWithMiddleware("GET", "/rap/file/{id}", app.FindFile, app.JWTHandler)
-	app.AddRouteWithMiddleware("GET", "/rap/files/{parent_id}", app.FindFiles, app.JWTHandler)
-	app.AddRouteWithMiddleware("DELETE", "/rap/file/{id}", app.DeleteFile, app.JWTHandler)
-	app.AddRouteWithMiddleware("DELETE", "/rap/files/{parent_id}", app.DeleteFiles, app.JWTHandler)
+	app.AddRouteWithMiddleware("POST", "/rap/file/{parent_id}", app.AddFile, app.APIKeyHandler(dto.ScopeFilesWrite))
+	app.AddRouteWithMiddleware("GET", "/rap/file/{id}", app.FindFile, app.APIKeyHandler(dto.ScopeFilesRead))
+	app.AddRouteWithMiddleware("GET", "/rap/files/{parent_id}", app.FindFiles, app.APIKeyHandler(dto.ScopeFilesRead))
+	app.AddRouteWithMiddleware("DELETE", "/rap/file/{id}", app.DeleteFile, app.APIKeyHandler(dto.ScopeFilesWrite))
+	app.AddRouteWithMiddleware("DELETE", "/rap/files/{parent_id}", app.DeleteFiles, app.APIKeyHandler(dto.ScopeFilesWrite))
 }
 
 //HealthCheck checks application status
@@ -65,16 +115,34 @@ func (app *App) JWTHandler(next http.Handler) http.Handler {
 				app.RenderErrorResponse(response, http.StatusForbidden, nil, "Invalid authorization token")
 				return
 			}
-			result, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-				return []byte(app.conf.PasswordKey), nil
-			})
-			if err == nil && result.Valid {
-				next.ServeHTTP(response, request)
-				return
-			} else {
+			claims, err := app.parseAccessToken(token)
+			if err != nil {
 				app.RenderErrorResponse(response, http.StatusForbidden, nil, "Invalid login token or expired")
 				return
 			}
+			if claims.TwoFactorPending {
+				app.RenderErrorResponse(response, http.StatusForbidden, nil, "Two factor authentication required")
+				return
+			}
+			if app.revocationRepo.IsRevoked(claims.Id, claims.SessionID, claims.Subject, time.Unix(claims.IssuedAt, 0)) {
+				app.RenderErrorResponse(response, http.StatusForbidden, nil, "Token has been revoked")
+				return
+			}
+			// impersonation ends once the tokens of the admin are revoked
+			if claims.Actor != nil && app.revocationRepo.IsRevoked("", "", claims.Actor.Subject, time.Unix(claims.IssuedAt, 0)) {
+				app.RenderErrorResponse(response, http.StatusForbidden, nil, "Token has been revoked")
+				return
+			}
+			if claims.SessionID != "" {
+				app.sessionRepo.SessionSeen(request.Context(), claims.SessionID, clientIP(request), request.UserAgent())
+			}
+			request = request.WithContext(auth.WithPrincipal(request.Context(), claims.principal()))
+			if claims.Actor != nil {
+				app.serveImpersonated(response, request, next)
+				return
+			}
+			next.ServeHTTP(response, request)
+			return
 		}
 		app.RenderErrorResponse(response, http.StatusForbidden, nil, "Bad token")
 		return
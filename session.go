@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// GetSessions lists the active login sessions of the authenticated user
+func (app *App) GetSessions(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	sessions, errResponse := app.sessionRepo.GetUserSessions(request.Context(), principal.UserID)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == principal.SessionID
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, sessions)
+}
+
+// RevokeSession logs out a session of the authenticated user, its tokens are rejected from now on
+func (app *App) RevokeSession(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	id := mux.Vars(request)["id"]
+	if errResponse := app.revocationRepo.RevokeSession(request.Context(), principal.UserID, id); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("Session revoked", zap.String("email", principal.Email), zap.String("session", id))
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, "Session revoked")
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// Claims are the claims of access tokens issued by the api
+type Claims struct {
+	Email      string `json:"username"`
+	IsUsing2FA bool   `json:"isUsing2FA"`
+	// set on tokens which are only good for the second login step
+	TwoFactorPending bool     `json:"2fa_pending,omitempty"`
+	Roles            []string `json:"roles,omitempty"`
+	// login session, the refresh token family the token was issued with
+	SessionID string `json:"sid,omitempty"`
+	// admin acting as the subject of an impersonation token
+	Actor *ActorClaim `json:"act,omitempty"`
+	// organization the token acts in and the role of the subject in it
+	TenantID   string `json:"tid,omitempty"`
+	TenantRole string `json:"tenant_role,omitempty"`
+	jwt.StandardClaims
+}
+
+// ActorClaim identifies the admin behind an impersonation token
+type ActorClaim struct {
+	Subject string `json:"sub"`
+	Email   string `json:"username,omitempty"`
+}
+
+// principal converts claims of a verified token to the request principal
+func (c *Claims) principal() *auth.Principal {
+	principal := &auth.Principal{
+		UserID:     c.Subject,
+		Email:      c.Email,
+		Roles:      c.Roles,
+		TokenID:    c.Id,
+		ExpiresAt:  time.Unix(c.ExpiresAt, 0),
+		SessionID:  c.SessionID,
+		TenantID:   c.TenantID,
+		TenantRole: c.TenantRole,
+	}
+	if c.Actor != nil {
+		principal.Actor = &auth.Actor{UserID: c.Actor.Subject, Email: c.Actor.Email}
+	}
+	return principal
+}
+
+// JWKS publishes the public keys tokens can be verified with
+func (app *App) JWKS(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Cache-Control", "public, max-age=300")
+	app.RenderJSON(writer, http.StatusOK, app.keySet.JWKS())
+}
+
+// RefreshToken exchanges a refresh token for a new access and refresh token pair
+func (app *App) RefreshToken(writer http.ResponseWriter, request *http.Request) {
+	var req dto.RefreshTokenRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Refresh token is not defined")
+		return
+	}
+
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to refresh token")
+		return
+	}
+	expires := time.Now().Add(app.conf.AuthConfig.RefreshTokenTTL)
+	rotated, errResponse := app.refreshTokenRepo.RotateRefreshToken(hashToken(req.RefreshToken), hashToken(refreshToken), expires)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	user, errResponse := app.userRepo.GetUserByID(rotated.UserID)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Debug("Refreshed token", zap.String("email", user.Email))
+
+	sessionID := rotated.FamilyID.String()
+	orgID, errResponse := app.sessionRepo.RefreshSession(request.Context(), sessionID, clientIP(request), request.UserAgent(), expires)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	membership, errResponse := app.sessionTenant(request.Context(), sessionID, user.ID, orgID)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	accessToken, err := app.newAccessToken(user, sessionID, membership)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusForbidden, err, "Failed to refresh token")
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, dto.JWTToken{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(app.conf.AuthConfig.AccessTokenTTL.Seconds()),
+	})
+}
+
+// issueTokens creates an access token and a refresh token starting a new token family,
+// the family is recorded as a session of the client of request
+func (app *App) issueTokens(request *http.Request, user *dto.User) (*dto.JWTToken, *dto.ErrorResponse) {
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Login Failed"}
+	}
+	userID, err := uuid.FromString(user.ID)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Login Failed"}
+	}
+	familyID, err := uuid.NewV4()
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Login Failed"}
+	}
+	expires := time.Now().Add(app.conf.AuthConfig.RefreshTokenTTL)
+	if _, errResponse := app.refreshTokenRepo.CreateRefreshToken(userID, familyID, hashToken(refreshToken), expires); errResponse != nil {
+		return nil, errResponse
+	}
+	membership, errResponse := app.orgRepo.DefaultMembership(request.Context(), user.ID)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+	orgID := ""
+	if membership != nil {
+		orgID = membership.OrgID
+	}
+	if errResponse := app.sessionRepo.CreateSession(request.Context(), familyID.String(), user.ID, orgID, clientIP(request), request.UserAgent(), expires); errResponse != nil {
+		return nil, errResponse
+	}
+
+	accessToken, err := app.newAccessToken(user, familyID.String(), membership)
+	if err != nil {
+		return nil, &dto.ErrorResponse{Status: http.StatusForbidden, Error: err, Message: "Login Failed"}
+	}
+
+	return &dto.JWTToken{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(app.conf.AuthConfig.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// sessionTenant returns the membership the tokens of a session act in, the organization
+// orgID of the session while user is still a member of it and else the one joined first
+func (app *App) sessionTenant(ctx context.Context, sessionID string, userID string, orgID string) (*dto.Membership, *dto.ErrorResponse) {
+	if orgID != "" {
+		membership, errResponse := app.orgRepo.GetMembership(ctx, orgID, userID)
+		if errResponse == nil || errResponse.Status != http.StatusNotFound {
+			return membership, errResponse
+		}
+	}
+	membership, errResponse := app.orgRepo.DefaultMembership(ctx, userID)
+	if errResponse != nil {
+		return nil, errResponse
+	}
+	if membership != nil {
+		errResponse = app.sessionRepo.SetSessionTenant(ctx, sessionID, membership.OrgID)
+	} else if orgID != "" {
+		errResponse = app.sessionRepo.SetSessionTenant(ctx, sessionID, "")
+	}
+	return membership, errResponse
+}
+
+// newAccessToken signs a short lived access token for user in session acting in
+// the organization of membership, nil outside of any
+func (app *App) newAccessToken(user *dto.User, sessionID string, membership *dto.Membership) (string, error) {
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := Claims{
+		Email:      user.Email,
+		IsUsing2FA: user.IsUsing2FA,
+		Roles:      []string{user.Role},
+		SessionID:  sessionID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti.String(),
+			Subject:   user.ID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(app.conf.AuthConfig.AccessTokenTTL).Unix(),
+		},
+	}
+	if membership != nil {
+		claims.TenantID, claims.TenantRole = membership.OrgID, membership.Role
+	}
+	return app.keySet.Sign(claims)
+}
+
+// parseAccessToken verifies signature and expiry of an access token and returns its claims
+func (app *App) parseAccessToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, app.keySet.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// generateOpaqueToken creates a random url safe token
+func generateOpaqueToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// hashToken hashes opaque tokens before they are stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
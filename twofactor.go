@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gofrs/uuid"
+	"github.com/mehmetkule/go-restapi/internal/auth"
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/totp"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+const recoveryCodeCount = 10
+
+// EnrollTwoFactor generates a new totp secret for the authenticated user
+func (app *App) EnrollTwoFactor(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to enroll two factor authentication")
+		return
+	}
+	if errResponse := app.twoFactorRepo.SetTOTPSecret(principal.UserID, secret); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, dto.TwoFactorEnrollResponse{
+		Secret: secret,
+		URI:    totp.URI(app.conf.AppName, principal.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor enables two factor authentication once the user proves a valid code
+func (app *App) ConfirmTwoFactor(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	var req dto.TwoFactorCodeRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+
+	state, errResponse := app.twoFactorRepo.GetTOTP(principal.UserID)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if state.Confirmed {
+		app.RenderErrorResponse(writer, http.StatusConflict, nil, "Two factor authentication is already enabled")
+		return
+	}
+	if state.Secret == "" {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Two factor authentication is not enrolled")
+		return
+	}
+	step, valid := totp.Validate(state.Secret, strings.TrimSpace(req.Code), time.Now())
+	if !valid {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to generate recovery codes")
+		return
+	}
+	if errResponse = app.twoFactorRepo.ConfirmTOTP(principal.UserID, step, hashes); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("Two factor authentication enabled", zap.String("email", principal.Email))
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, dto.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor disables two factor authentication after verifying a code
+func (app *App) DisableTwoFactor(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "No token given")
+		return
+	}
+	var req dto.TwoFactorCodeRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+
+	if errResponse := app.verifySecondFactor(principal.UserID, req.Code); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if errResponse := app.twoFactorRepo.DisableTOTP(principal.UserID); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, "Two factor authentication disabled")
+}
+
+// LoginTwoFactor exchanges a pending two factor token and a code for full tokens,
+// the pending token is used up by the first valid code
+func (app *App) LoginTwoFactor(writer http.ResponseWriter, request *http.Request) {
+	var req dto.TwoFactorLoginRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+
+	claims, err := app.parseAccessToken(req.Token)
+	if err != nil || !claims.TwoFactorPending || claims.Id == "" {
+		app.RenderErrorResponse(writer, http.StatusForbidden, err, "Invalid login token or expired")
+		return
+	}
+	// password changes and revoking all sessions end pending logins too
+	if app.revocationRepo.IsRevoked(claims.Id, "", claims.Subject, time.Unix(claims.IssuedAt, 0)) {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Token has been revoked")
+		return
+	}
+	user, errResponse := app.userRepo.GetUserByID(uuid.FromStringOrNil(claims.Subject))
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if app.loginThrottled(writer, request, user) {
+		return
+	}
+	if errResponse = app.verifySecondFactor(claims.Subject, req.Code); errResponse != nil {
+		if errResponse.Status == http.StatusForbidden {
+			app.recordLoginFailure(request.Context(), request, user)
+		}
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	unused, errResponse := app.revocationRepo.ConsumeToken(claims.Id, claims.Subject, time.Unix(claims.ExpiresAt, 0))
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if !unused {
+		app.RenderErrorResponse(writer, http.StatusForbidden, nil, "Token has been revoked")
+		return
+	}
+	app.recordLoginSuccess(request.Context(), user)
+
+	response, errResponse := app.issueTokens(request, user)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, response)
+}
+
+// renderTwoFactorChallenge answers a password login with a short lived pending token
+func (app *App) renderTwoFactorChallenge(writer http.ResponseWriter, user *dto.User) {
+	jti, err := uuid.NewV4()
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Login Failed")
+		return
+	}
+	now := time.Now()
+	claims := Claims{
+		Email:            user.Email,
+		TwoFactorPending: true,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti.String(),
+			Subject:   user.ID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(app.conf.AuthConfig.TwoFactorTokenTTL).Unix(),
+		},
+	}
+	token, err := app.keySet.Sign(claims)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusForbidden, err, "Login Failed")
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, dto.TwoFactorChallenge{
+		TwoFactorRequired: true,
+		Token:             token,
+		ExpiresIn:         int64(app.conf.AuthConfig.TwoFactorTokenTTL.Seconds()),
+	})
+}
+
+// verifySecondFactor accepts a totp code or an unused recovery code of user
+func (app *App) verifySecondFactor(userID string, code string) *dto.ErrorResponse {
+	code = strings.TrimSpace(code)
+	state, errResponse := app.twoFactorRepo.GetTOTP(userID)
+	if errResponse != nil {
+		return errResponse
+	}
+	if !state.Confirmed {
+		return &dto.ErrorResponse{Status: http.StatusBadRequest, Error: nil, Message: "Two factor authentication is not enabled"}
+	}
+
+	var valid bool
+	if step, ok := totp.Validate(state.Secret, code, time.Now()); ok {
+		// a code is accepted only once
+		if valid, errResponse = app.twoFactorRepo.UseTOTPStep(userID, step); errResponse != nil {
+			return errResponse
+		}
+	} else if valid, errResponse = app.twoFactorRepo.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code))); errResponse != nil {
+		return errResponse
+	}
+	if !valid {
+		return &dto.ErrorResponse{Status: http.StatusForbidden, Error: nil, Message: "Invalid code"}
+	}
+	return nil
+}
+
+// generateRecoveryCodes creates recovery codes and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	var codes, hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		data := make([]byte, 7)
+		if _, err := rand.Read(data); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(data))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode removes formatting users may type with a recovery code
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
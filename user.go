@@ -2,10 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/mergepatch"
 	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
@@ -28,35 +36,55 @@ func (app *App) Register(writer http.ResponseWriter, req *http.Request) {
 		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to convert json code")
 		return
 	}
+	request.Email = dto.NormalizeEmail(request.Email)
 	// validate fields
 	if status, errValidate := request.ValidateUser(); errValidate != nil {
 		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
 		return
 	}
-	response, _ := app.userRepo.FindUserByEmail(request.Email)
-	if response != nil {
-		app.RenderErrorResponse(writer, http.StatusConflict, err, "Conflict Email")
+	if errResponse := app.checkPasswordPolicy(request.Password, request.Email); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
 	}
 
-	// insert message
-	response, errResponse := app.userRepo.CreateUser(request)
+	// insert message, the unique email index rejects taken addresses with a conflict
+	response, errResponse := app.userRepo.CreateUser(req.Context(), request)
 	if errResponse != nil {
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
 	}
 
+	// the user is created already, a failed verification email can be requested again
+	if errResponse = app.sendVerificationEmail(req.Context(), response.ID, response.Email); errResponse != nil {
+		logger.Logger().Error("Failed to send verification email", zap.String("email", response.Email), zap.Error(errResponse.Error))
+	}
+
 	// render output
 	app.RenderJSON(writer, http.StatusOK, response)
 
 }
 
+// GetUsers lists a page of users, the query selects filters, sort order, limit and cursor
 func (app *App) GetUsers(writer http.ResponseWriter, req *http.Request) {
-	response, errResponse := app.userRepo.GetUsers()
+	request, err := parseUserListRequest(req.URL.Query())
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Validation error")
+		return
+	}
+	if status, errValidate := request.ValidateUserList(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+	response, errResponse := app.userRepo.GetUsers(req.Context(), request)
 	if errResponse != nil {
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
 	}
+	if response.NextCursor != "" {
+		query := req.URL.Query()
+		query.Set("cursor", response.NextCursor)
+		response.Next = app.conf.ServerConfig.PublicURL + req.URL.Path + "?" + query.Encode()
+	}
 	// render output
 	app.RenderJSON(writer, http.StatusOK, response)
 }
@@ -69,7 +97,7 @@ func (app *App) FindUserByID(writer http.ResponseWriter, req *http.Request) {
 		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid id")
 
 	}
-	response, errResponse := app.userRepo.FindUserByID(uuid)
+	response, errResponse := app.userRepo.FindUserByID(req.Context(), uuid)
 	if errResponse != nil {
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
@@ -86,7 +114,7 @@ func (app *App) FindUserByEmail(writer http.ResponseWriter, req *http.Request) {
 		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Invalid email")
 		return
 	}
-	response, errResponse := app.userRepo.FindUserByEmail(email)
+	response, errResponse := app.userRepo.FindUserByEmail(req.Context(), email)
 	if errResponse != nil {
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
@@ -99,11 +127,230 @@ func (app *App) DeleteUser(writer http.ResponseWriter, req *http.Request) {
 	params := mux.Vars(req)
 	id := params["id"]
 
-	errResponse := app.userRepo.DeleteUser(id)
+	errResponse := app.userRepo.DeleteUser(req.Context(), id)
 	if errResponse != nil {
 		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
 		return
 	}
+	// deleted users keep their rows until purged, their tokens must not
+	if errResponse = app.revokeAllSessions(id); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
 	app.RenderJSON(writer, http.StatusOK, "")
 }
 
+// RestoreUser undoes the deletion of a user which was not purged yet
+func (app *App) RestoreUser(writer http.ResponseWriter, req *http.Request) {
+	id, err := uuid.FromString(mux.Vars(req)["id"])
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid id")
+		return
+	}
+	if errResponse := app.userRepo.RestoreUser(req.Context(), id.String()); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("User restored", zap.String("id", id.String()))
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, "User restored")
+}
+
+
+
+// SetUserRole changes the role of a user
+func (app *App) SetUserRole(writer http.ResponseWriter, req *http.Request) {
+	params := mux.Vars(req)
+	id := params["id"]
+
+	var request dto.RoleRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if status, errValidate := request.ValidateRole(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+
+	if errResponse := app.userRepo.SetUserRole(req.Context(), id, request.Role); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	// access tokens carry the old role, refreshing issues tokens with the new one
+	if errResponse := app.revocationRepo.RevokeUserTokens(id); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, "")
+}
+
+// UpdateUser replaces the profile of a user
+func (app *App) UpdateUser(writer http.ResponseWriter, req *http.Request) {
+	current, ok := app.userForUpdate(writer, req)
+	if !ok {
+		return
+	}
+	var request dto.UserUpdateRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	app.updateUser(writer, req, current, request)
+}
+
+// PatchUser applies a json merge patch to the profile of a user
+func (app *App) PatchUser(writer http.ResponseWriter, req *http.Request) {
+	current, ok := app.userForUpdate(writer, req)
+	if !ok {
+		return
+	}
+	app.patchUser(writer, req, current, nil)
+}
+
+// patchUser applies the json merge patch of the request body to the profile of user current,
+// a patch of fields missing in editable is rejected unless editable is nil
+func (app *App) patchUser(writer http.ResponseWriter, req *http.Request, current *dto.UserResponse, editable map[string]bool) {
+	if contentType := req.Header.Get("Content-Type"); contentType != "" &&
+		!strings.HasPrefix(contentType, mergepatch.ContentType) && !strings.HasPrefix(contentType, "application/json") {
+		app.RenderErrorResponse(writer, http.StatusUnsupportedMediaType, nil, "Unsupported content type")
+		return
+	}
+	patch, err := io.ReadAll(req.Body)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to read request body")
+		return
+	}
+	if editable != nil {
+		var fields map[string]json.RawMessage
+		if err = json.Unmarshal(patch, &fields); err != nil {
+			app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+			return
+		}
+		validationErr := &dto.ValidationError{}
+		for field := range fields {
+			if !editable[field] {
+				validationErr.Fields = append(validationErr.Fields, dto.FieldError{Field: field, Reason: "can not be changed"})
+			}
+		}
+		if len(validationErr.Fields) > 0 {
+			sort.Slice(validationErr.Fields, func(i, j int) bool { return validationErr.Fields[i].Field < validationErr.Fields[j].Field })
+			app.RenderErrorResponse(writer, http.StatusBadRequest, validationErr, "Validation error")
+			return
+		}
+	}
+
+	// the patch is applied to the fields a put would replace
+	doc, err := json.Marshal(dto.UserUpdateRequest{
+		FirstName: current.FirstName,
+		LastName:  current.LastName,
+		Email:     current.Email,
+	})
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusInternalServerError, err, "Failed to update user")
+		return
+	}
+	merged, err := mergepatch.Apply(doc, patch)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	var request dto.UserUpdateRequest
+	if err = json.Unmarshal(merged, &request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	app.updateUser(writer, req, current, request)
+}
+
+// userForUpdate finds the user of the id route parameter, rendering an error if there is none
+func (app *App) userForUpdate(writer http.ResponseWriter, req *http.Request) (*dto.UserResponse, bool) {
+	id, err := uuid.FromString(mux.Vars(req)["id"])
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Invalid id")
+		return nil, false
+	}
+	current, errResponse := app.userRepo.FindUserByID(req.Context(), id)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return nil, false
+	}
+	return current, true
+}
+
+// updateUser validates and stores the new profile of user current
+func (app *App) updateUser(writer http.ResponseWriter, req *http.Request, current *dto.UserResponse, request dto.UserUpdateRequest) {
+	request.Email = dto.NormalizeEmail(request.Email)
+	if status, errValidate := request.ValidateUserUpdate(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+
+	response, errResponse := app.userRepo.UpdateUser(req.Context(), current.ID, request)
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("User updated", zap.String("id", response.ID))
+
+	// the new address is unverified until the user follows the verification email
+	if !strings.EqualFold(response.Email, current.Email) {
+		if errResponse = app.sendVerificationEmail(req.Context(), response.ID, response.Email); errResponse != nil {
+			logger.Logger().Error("Failed to send verification email", zap.String("email", response.Email), zap.Error(errResponse.Error))
+		}
+	}
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, response)
+}
+
+// parseUserListRequest reads a user listing request from query
+func parseUserListRequest(query url.Values) (dto.UserListRequest, error) {
+	request := dto.UserListRequest{
+		Email:  query.Get("email"),
+		Name:   query.Get("name"),
+		Sort:   query.Get("sort"),
+		Limit:  dto.DefaultUserListLimit,
+		Cursor: query.Get("cursor"),
+	}
+	if request.Sort == "" {
+		request.Sort = "created"
+	}
+	var err error
+	if value := query.Get("limit"); value != "" {
+		if request.Limit, err = strconv.Atoi(value); err != nil {
+			return request, fmt.Errorf("Limit is wrong")
+		}
+	}
+	if value := query.Get("include_deleted"); value != "" {
+		if request.IncludeDeleted, err = strconv.ParseBool(value); err != nil {
+			return request, fmt.Errorf("include_deleted is wrong")
+		}
+	}
+	if value := query.Get("is_2fa"); value != "" {
+		isUsing2FA, errParse := strconv.ParseBool(value)
+		if errParse != nil {
+			return request, fmt.Errorf("is_2fa is wrong")
+		}
+		request.IsUsing2FA = &isUsing2FA
+	}
+	if request.CreatedFrom, err = parseDateParam(query, "created_from"); err != nil {
+		return request, err
+	}
+	request.CreatedTo, err = parseDateParam(query, "created_to")
+	return request, err
+}
+
+// parseDateParam reads an optional date of the form 2006-01-02 from query
+func parseDateParam(query url.Values, name string) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("%s is wrong", name)
+	}
+	return &date, nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// media types of user imports and exports
+const (
+	contentTypeCSV    = "text/csv"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// limits of a single user import
+const (
+	maxImportSize = 10 << 20
+	maxImportRows = 1000
+)
+
+// requiredImportColumns must be present in the header of csv imports
+var requiredImportColumns = []string{"first_name", "last_name", "email", "password"}
+
+// exportColumns are the csv columns of user exports
+var exportColumns = []string{"id", "first_name", "last_name", "email", "is_2fa", "role", "deleted_at"}
+
+// ImportUsers creates users from a csv or ndjson body. Every row is validated like
+// Register, dry_run only reports the results and mode chooses whether one invalid
+// row fails the whole import or only itself.
+func (app *App) ImportUsers(writer http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	response := dto.UserImportResponse{Mode: query.Get("mode")}
+	if response.Mode == "" {
+		response.Mode = dto.ImportTransactional
+	}
+	if response.Mode != dto.ImportTransactional && response.Mode != dto.ImportBestEffort {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, fmt.Errorf("mode is wrong"), "Validation error")
+		return
+	}
+	if value := query.Get("dry_run"); value != "" {
+		var err error
+		if response.DryRun, err = strconv.ParseBool(value); err != nil {
+			app.RenderErrorResponse(writer, http.StatusBadRequest, fmt.Errorf("dry_run is wrong"), "Validation error")
+			return
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	body := http.MaxBytesReader(writer, req.Body, maxImportSize)
+	var requests []dto.UserRequest
+	var err error
+	switch mediaType {
+	case contentTypeCSV:
+		requests, response.Rows, err = readCSVImport(body)
+	case contentTypeNDJSON, "application/ndjson":
+		requests, response.Rows, err = readNDJSONImport(body)
+	default:
+		app.RenderErrorResponse(writer, http.StatusUnsupportedMediaType, nil, "Unsupported content type")
+		return
+	}
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to read import")
+		return
+	}
+	response.Total = len(response.Rows)
+	if errResponse := app.validateImport(req.Context(), requests, response.Rows); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+
+	status := http.StatusOK
+	switch {
+	case response.DryRun:
+	case response.Mode == dto.ImportBestEffort:
+		app.importBestEffort(req.Context(), requests, response.Rows)
+	default:
+		var errResponse *dto.ErrorResponse
+		if status, errResponse = app.importTransactional(req.Context(), requests, response.Rows); errResponse != nil {
+			app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+			return
+		}
+	}
+
+	for i, row := range response.Rows {
+		switch row.Status {
+		case dto.ImportRowCreated:
+			response.Created++
+			// the users are created already, a failed verification email can be requested again
+			if errResponse := app.sendVerificationEmail(req.Context(), row.ID, requests[i].Email); errResponse != nil {
+				logger.Logger().Error("Failed to send verification email", zap.String("email", requests[i].Email), zap.Error(errResponse.Error))
+			}
+		case dto.ImportRowFailed:
+			response.Failed++
+		}
+	}
+	logger.Logger().Info("Imported users", zap.String("mode", response.Mode), zap.Bool("dryRun", response.DryRun),
+		zap.Int("created", response.Created), zap.Int("failed", response.Failed))
+
+	// render output
+	app.RenderJSON(writer, status, response)
+}
+
+// validateImport fails the rows whose request Register would reject, whose email
+// is taken or which repeat the email of an earlier row
+func (app *App) validateImport(ctx context.Context, requests []dto.UserRequest, rows []dto.UserImportRow) *dto.ErrorResponse {
+	seen := map[string]bool{}
+	var emails []string
+	for i := range requests {
+		if rows[i].Status != dto.ImportRowValid {
+			continue
+		}
+		request := &requests[i]
+		request.Email = dto.NormalizeEmail(request.Email)
+		rows[i].Email = request.Email
+		if _, errValidate := request.ValidateUser(); errValidate != nil {
+			rows[i].Status, rows[i].Error = dto.ImportRowFailed, errValidate.Error()
+			continue
+		}
+		if errResponse := app.checkPasswordPolicy(request.Password, request.Email); errResponse != nil {
+			rows[i].Status, rows[i].Error = dto.ImportRowFailed, errResponse.Message
+			var validationErr *dto.ValidationError
+			if errors.As(errResponse.Error, &validationErr) {
+				rows[i].Fields = validationErr.Fields
+			}
+			continue
+		}
+		if seen[request.Email] {
+			rows[i].Status, rows[i].Error = dto.ImportRowFailed, "Duplicate Email"
+			continue
+		}
+		seen[request.Email] = true
+		emails = append(emails, request.Email)
+	}
+	if len(emails) == 0 {
+		return nil
+	}
+
+	existing, errResponse := app.userRepo.ExistingEmails(ctx, emails)
+	if errResponse != nil {
+		return errResponse
+	}
+	for i := range rows {
+		if rows[i].Status == dto.ImportRowValid && existing[requests[i].Email] {
+			rows[i].Status, rows[i].Error = dto.ImportRowFailed, "Conflict Email"
+		}
+	}
+	return nil
+}
+
+// importTransactional creates the users of all rows or, if any row failed, none
+// of them and returns the status to respond with
+func (app *App) importTransactional(ctx context.Context, requests []dto.UserRequest, rows []dto.UserImportRow) (int, *dto.ErrorResponse) {
+	var valid []dto.UserRequest
+	var indexes []int
+	for i := range rows {
+		if rows[i].Status == dto.ImportRowValid {
+			valid = append(valid, requests[i])
+			indexes = append(indexes, i)
+		}
+	}
+	if len(valid) < len(rows) {
+		skipRows(rows)
+		return http.StatusUnprocessableEntity, nil
+	}
+	if len(valid) == 0 {
+		return http.StatusOK, nil
+	}
+
+	ids, failed, errResponse := app.userRepo.CreateUsers(ctx, valid)
+	if errResponse != nil && failed < 0 {
+		return 0, errResponse
+	}
+	if errResponse != nil {
+		// e.g. an email registered since validating
+		row := &rows[indexes[failed]]
+		row.Status, row.Error = dto.ImportRowFailed, errResponse.Message
+		skipRows(rows)
+		return http.StatusUnprocessableEntity, nil
+	}
+	for i, id := range ids {
+		rows[indexes[i]].Status, rows[indexes[i]].ID = dto.ImportRowCreated, id
+	}
+	return http.StatusOK, nil
+}
+
+// importBestEffort creates the users of the valid rows one by one
+func (app *App) importBestEffort(ctx context.Context, requests []dto.UserRequest, rows []dto.UserImportRow) {
+	for i := range rows {
+		if rows[i].Status != dto.ImportRowValid {
+			continue
+		}
+		response, errResponse := app.userRepo.CreateUser(ctx, requests[i])
+		if errResponse != nil {
+			rows[i].Status, rows[i].Error = dto.ImportRowFailed, errResponse.Message
+			continue
+		}
+		rows[i].Status, rows[i].ID = dto.ImportRowCreated, response.ID
+	}
+}
+
+// skipRows marks the valid rows skipped because the import failed
+func skipRows(rows []dto.UserImportRow) {
+	for i := range rows {
+		if rows[i].Status == dto.ImportRowValid {
+			rows[i].Status = dto.ImportRowSkipped
+		}
+	}
+}
+
+// readCSVImport reads user requests from csv with a header row naming the columns,
+// rows which cannot be read are failed, columns which are not needed are ignored
+// like the id, is_2fa and role columns of exports
+func readCSVImport(body io.Reader) ([]dto.UserRequest, []dto.UserImportRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("import is empty")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		// spreadsheet programs like to start files with a byte order mark
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	for _, name := range requiredImportColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("column %s is missing", name)
+		}
+	}
+
+	var requests []dto.UserRequest
+	var rows []dto.UserImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return requests, rows, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rows) == maxImportRows {
+			return nil, nil, fmt.Errorf("import has more than %d rows", maxImportRows)
+		}
+		row := dto.UserImportRow{Row: len(rows) + 1, Status: dto.ImportRowValid}
+		value := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		request := dto.UserRequest{
+			FirstName: value("first_name"),
+			LastName:  value("last_name"),
+			Email:     value("email"),
+			Password:  value("password"),
+		}
+		row.Email = request.Email
+		if len(record) != len(header) {
+			row.Status, row.Error = dto.ImportRowFailed, "Wrong number of columns"
+		}
+		requests = append(requests, request)
+		rows = append(rows, row)
+	}
+}
+
+// readNDJSONImport reads user requests from json objects on separate lines, lines
+// which are no user request are failed
+func readNDJSONImport(body io.Reader) ([]dto.UserRequest, []dto.UserImportRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	var requests []dto.UserRequest
+	var rows []dto.UserImportRow
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, nil, fmt.Errorf("import has more than %d rows", maxImportRows)
+		}
+		row := dto.UserImportRow{Row: len(rows) + 1, Status: dto.ImportRowValid}
+		var request dto.UserRequest
+		if err := json.Unmarshal(line, &request); err != nil {
+			row.Status, row.Error = dto.ImportRowFailed, "Failed to convert json code"
+		}
+		row.Email = request.Email
+		requests = append(requests, request)
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("import is empty")
+	}
+	return requests, rows, nil
+}
+
+// ExportUsers streams the users matching the filters and sort order of GetUsers
+// as csv or, with format=ndjson, as json objects on separate lines
+func (app *App) ExportUsers(writer http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	request, err := parseUserListRequest(query)
+	if err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Validation error")
+		return
+	}
+	if status, errValidate := request.ValidateUserList(); errValidate != nil {
+		app.RenderErrorResponse(writer, status, errValidate, "Validation error")
+		return
+	}
+
+	var write func(user dto.UserResponse) error
+	var flush func() error
+	switch format := query.Get("format"); format {
+	case "", "csv":
+		csvWriter := csv.NewWriter(writer)
+		writer.Header().Set("Content-Type", contentTypeCSV)
+		writer.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+		if err = csvWriter.Write(exportColumns); err != nil {
+			logger.Logger().Error("Failed to export users", zap.Error(err))
+			return
+		}
+		write = func(user dto.UserResponse) error {
+			deletedAt := ""
+			if user.DeletedAt != nil {
+				deletedAt = user.DeletedAt.Format(time.RFC3339)
+			}
+			return csvWriter.Write([]string{user.ID, user.FirstName, user.LastName, user.Email, strconv.FormatBool(user.IsUsing2FA), user.Role, deletedAt})
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	case "ndjson":
+		encoder := json.NewEncoder(writer)
+		writer.Header().Set("Content-Type", contentTypeNDJSON)
+		writer.Header().Set("Content-Disposition", `attachment; filename="users.ndjson"`)
+		write = func(user dto.UserResponse) error {
+			return encoder.Encode(user)
+		}
+		flush = func() error { return nil }
+	default:
+		app.RenderErrorResponse(writer, http.StatusBadRequest, fmt.Errorf("format %q is unknown", format), "Validation error")
+		return
+	}
+
+	// the status is sent with the first row, failures later on can only be logged
+	if err = app.userRepo.ExportUsers(req.Context(), request, write); err == nil {
+		err = flush()
+	}
+	if err != nil {
+		logger.Logger().Error("Failed to export users", zap.Error(err))
+	}
+}
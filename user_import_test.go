@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/store"
+)
+
+func TestReadCSVImportIgnoresExportColumns(t *testing.T) {
+	body := "\ufeffid,first_name,last_name,email,is_2fa,role,deleted_at,password\n" +
+		"1,Jane,Doe,jane@example.com,true,admin,,Correct-Horse-42\n" +
+		"2,John,Doe,john@example.com,not a bool,user,,Correct-Horse-43\n" +
+		"3,Joan,Doe,joan@example.com,false,user,\n"
+	requests, rows, err := readCSVImport(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 3 || len(rows) != 3 {
+		t.Fatalf("read %d requests and %d rows, want 3", len(requests), len(rows))
+	}
+	want := dto.UserRequest{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: "Correct-Horse-42"}
+	if requests[0] != want || rows[0].Status != dto.ImportRowValid {
+		t.Errorf("row 1: request %+v, status %s %s", requests[0], rows[0].Status, rows[0].Error)
+	}
+	if rows[1].Status != dto.ImportRowValid {
+		t.Errorf("row 2: status %s %s, want the is_2fa column ignored", rows[1].Status, rows[1].Error)
+	}
+	if rows[2].Status != dto.ImportRowFailed || rows[2].Error != "Wrong number of columns" {
+		t.Errorf("row 3: status %s %s, want the short row failed", rows[2].Status, rows[2].Error)
+	}
+}
+
+func TestReadCSVImportRequiresColumns(t *testing.T) {
+	if _, _, err := readCSVImport(strings.NewReader("first_name,last_name,email\nJane,Doe,jane@example.com\n")); err == nil {
+		t.Error("import without password column accepted")
+	}
+	if _, _, err := readCSVImport(strings.NewReader("")); err == nil {
+		t.Error("empty import accepted")
+	}
+}
+
+func TestReadNDJSONImportIgnoresTwoFactor(t *testing.T) {
+	body := `{"first_name":"Jane","last_name":"Doe","email":"jane@example.com","password":"Correct-Horse-42","is_2fa":true}` + "\n\n" +
+		`not json` + "\n"
+	requests, rows, err := readNDJSONImport(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("read %d requests, want 2", len(requests))
+	}
+	want := dto.UserRequest{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: "Correct-Horse-42"}
+	if requests[0] != want || rows[0].Status != dto.ImportRowValid {
+		t.Errorf("row 1: request %+v, status %s %s", requests[0], rows[0].Status, rows[0].Error)
+	}
+	if rows[1].Status != dto.ImportRowFailed {
+		t.Errorf("row 2: status %s, want failed", rows[1].Status)
+	}
+}
+
+func TestImportUsersTransactionalRejectsInvalidRows(t *testing.T) {
+	conf := &Config{AppName: "test", AuthConfig: defaultAuthConfig(), PasswordPolicy: defaultPasswordPolicyConfig()}
+	policy, err := conf.PasswordPolicy.GetPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inserts int
+	db := newFakeDB(func(query string, args []driver.Value) (*fakeResult, error) {
+		if strings.HasPrefix(query, "INSERT") {
+			inserts++
+		}
+		return nil, nil
+	})
+	app := &App{conf: conf, userRepo: &store.UserRepo{DB: db}, passwordPolicy: policy, mailSender: newRecordingSender()}
+
+	body := "first_name,last_name,email,password\n" +
+		"Jane,Doe,jane@example.com,Correct-Horse-42\n" +
+		"John,,john@example.com,Correct-Horse-43\n"
+	request := httptest.NewRequest(http.MethodPost, "/rap/users/import?mode=transactional", strings.NewReader(body))
+	request.Header.Set("Content-Type", "text/csv")
+	recorder := httptest.NewRecorder()
+	app.ImportUsers(recorder, request)
+
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusUnprocessableEntity)
+	}
+	var response dto.UserImportResponse
+	if err = json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Created != 0 || response.Failed != 1 || len(response.Rows) != 2 ||
+		response.Rows[0].Status != dto.ImportRowSkipped || response.Rows[1].Status != dto.ImportRowFailed {
+		t.Errorf("response = %+v, want the valid row skipped and the invalid one failed", response)
+	}
+	if inserts != 0 {
+		t.Errorf("%d statements inserted rows of a rejected import", inserts)
+	}
+}
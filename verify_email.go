@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mehmetkule/go-restapi/internal/dto"
+	"github.com/mehmetkule/go-restapi/internal/store"
+	"github.com/mehmetkule/go-restapi/logger"
+	"go.uber.org/zap"
+)
+
+// VerifyEmail marks the email address of the user owning the verification token as verified
+func (app *App) VerifyEmail(writer http.ResponseWriter, req *http.Request) {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Token is not defined")
+		return
+	}
+
+	userID, errResponse := app.userTokenRepo.ConsumeUserToken(req.Context(), store.TokenPurposeEmailVerification, hashToken(token))
+	if errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	if errResponse = app.userRepo.SetEmailVerified(req.Context(), userID); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	logger.Logger().Info("Email verified", zap.String("userID", userID))
+
+	// render output
+	app.RenderJSON(writer, http.StatusOK, "Email verified")
+}
+
+// ResendVerification emails a new verification token to an unverified user
+func (app *App) ResendVerification(writer http.ResponseWriter, req *http.Request) {
+	var request dto.ResendVerificationRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, err, "Failed to convert json code")
+		return
+	}
+	if request.Email == "" {
+		app.RenderErrorResponse(writer, http.StatusBadRequest, nil, "Email is wrong")
+		return
+	}
+	if app.mailThrottled(writer, req, request.Email, "Too many verification emails requested") {
+		return
+	}
+
+	// the response is the same whether the account exists or not
+	const message = "If the account exists and is not verified a verification email has been sent"
+	user, errResponse := app.userRepo.GetUser(request.Email)
+	if errResponse != nil || user.EmailVerified {
+		app.RenderJSON(writer, http.StatusOK, message)
+		return
+	}
+	if errResponse = app.sendVerificationEmail(req.Context(), user.ID, user.Email); errResponse != nil {
+		app.RenderErrorResponse(writer, errResponse.Status, errResponse.Error, errResponse.Message)
+		return
+	}
+	app.RenderJSON(writer, http.StatusOK, message)
+}
+
+// sendVerificationEmail creates a verification token for user and emails the verification link
+func (app *App) sendVerificationEmail(ctx context.Context, userID string, email string) *dto.ErrorResponse {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return &dto.ErrorResponse{Status: http.StatusInternalServerError, Error: err, Message: "Failed to create verification token"}
+	}
+	expires := time.Now().Add(app.conf.AuthConfig.EmailVerificationTTL)
+	if errResponse := app.userTokenRepo.CreateUserToken(ctx, userID, store.TokenPurposeEmailVerification, hashToken(token), expires); errResponse != nil {
+		return errResponse
+	}
+
+	link := app.conf.ServerConfig.PublicURL + "/verify-email?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Welcome to %s.\n\nPlease confirm your email address by opening the link below, it is valid for %s:\n%s\n", app.conf.AppName, app.conf.AuthConfig.EmailVerificationTTL, link)
+	app.sendMail(email, "Verify your email address", body)
+	return nil
+}